@@ -206,6 +206,7 @@ func startZitadel(ctx context.Context, config *Config, masterKey string, server
 		config.AuditLogRetention,
 		config.SystemAPIUsers,
 		true,
+		append(config.InstanceQueries.Options(), query.WithExternalURL(config.ExternalSecure, config.ExternalPort))...,
 	)
 	if err != nil {
 		return fmt.Errorf("cannot start queries: %w", err)
@@ -2,6 +2,8 @@ package query
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/zitadel/logging"
 
@@ -12,6 +14,12 @@ import (
 
 type Caches struct {
 	instance cache.Cache[instanceIndex, string, *authzInstance]
+
+	// instanceMaxAge is the configured max age of the instance cache,
+	// used to determine when an instance is due for refresh ahead.
+	instanceMaxAge time.Duration
+	// instanceRefreshing holds the IDs of instances currently refreshed in the background.
+	instanceRefreshing sync.Map
 }
 
 func startCaches(background context.Context, connectors connector.Connectors) (_ *Caches, err error) {
@@ -20,6 +28,9 @@ func startCaches(background context.Context, connectors connector.Connectors) (_
 	if err != nil {
 		return nil, err
 	}
+	if conf := connectors.Config.Instance; conf != nil {
+		caches.instanceMaxAge = conf.MaxAge
+	}
 	caches.registerInstanceInvalidation()
 	return caches, nil
 }
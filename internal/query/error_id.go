@@ -0,0 +1,28 @@
+package query
+
+import (
+	"errors"
+
+	"github.com/zitadel/zitadel/internal/zerrors"
+)
+
+// ErrorByID returns the [zerrors.ZitadelError] with the ID from err or its parents.
+// It returns nil if none of them has the ID.
+func ErrorByID(err error, id string) *zerrors.ZitadelError {
+	for err != nil {
+		var zitadelErr *zerrors.ZitadelError
+		if !errors.As(err, &zitadelErr) {
+			return nil
+		}
+		if zitadelErr.GetID() == id {
+			return zitadelErr
+		}
+		err = zitadelErr.GetParent()
+	}
+	return nil
+}
+
+// ErrorHasID reports whether err or one of its parents is a [zerrors.ZitadelError] with the ID.
+func ErrorHasID(err error, id string) bool {
+	return ErrorByID(err, id) != nil
+}
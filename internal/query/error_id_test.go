@@ -0,0 +1,124 @@
+package query
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/zerrors"
+)
+
+// knownDuplicateErrorIDs lists the error IDs which were already thrown at several places
+// before the uniqueness check was introduced, with the number of places they are thrown at.
+// They are kept as they are because support relies on them. Don't add new entries.
+var knownDuplicateErrorIDs = map[string]int{
+	"QUERY-1b9mf": 2,
+	"QUERY-2j00f": 2,
+	"QUERY-3N9ff": 2,
+	"QUERY-3n9fl": 2,
+	"QUERY-5Ngd9": 2,
+	"QUERY-8nlWW": 2,
+	"QUERY-9k87F": 2,
+	"QUERY-AWx52": 2,
+	"QUERY-Dfbe2": 2,
+	"QUERY-Dg43g": 2,
+	"QUERY-Dgbg2": 2,
+	"QUERY-Dgff3": 2,
+	"QUERY-Dgfn3": 2,
+	"QUERY-EqJFc": 2,
+	"QUERY-M6mYN": 2,
+	"QUERY-Mr6H3": 2,
+	"QUERY-N34NV": 2,
+	"QUERY-Pdg1I": 2,
+	"QUERY-QMXJv": 3,
+	"QUERY-SKR6X": 2,
+	"QUERY-TYUCE": 2,
+	"QUERY-USNwM": 2,
+	"QUERY-XmYn9": 2,
+	"QUERY-aJnZL": 3,
+	"QUERY-dj2FF": 4,
+	"QUERY-dn9JW": 3,
+	"QUERY-ej8fn": 2,
+	"QUERY-fajp8": 2,
+	"QUERY-fwofw": 2,
+	"QUERY-iTTGJ": 2,
+	"QUERY-mN0Ci": 3,
+	"QUERY-pWS5H": 2,
+	"QUERY-rKd6k": 4,
+	"QUERY-scVHo": 2,
+	"QUERY-sn9Jf": 2,
+	"QUERY-yPqIZ": 2,
+	"QUERY-yxTMh": 2,
+}
+
+// TestErrorIDs_unique asserts that every error ID thrown in the package is thrown at exactly one place.
+func TestErrorIDs_unique(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+
+	thrown := make(map[string][]string)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		fset := token.NewFileSet()
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		require.NoError(t, err)
+		ast.Inspect(parsed, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !strings.HasPrefix(sel.Sel.Name, "Throw") || len(call.Args) < 2 {
+				return true
+			}
+			lit, ok := call.Args[1].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			id, err := strconv.Unquote(lit.Value)
+			require.NoError(t, err)
+			position := fset.Position(lit.Pos())
+			thrown[id] = append(thrown[id], fmt.Sprintf("%s:%d", position.Filename, position.Line))
+			return true
+		})
+	}
+	require.NotEmpty(t, thrown)
+
+	for id, locations := range thrown {
+		expected, ok := knownDuplicateErrorIDs[id]
+		if !ok {
+			expected = 1
+		}
+		assert.Len(t, locations, expected, "error ID %s is thrown in %v", id, locations)
+	}
+}
+
+func TestErrorByID(t *testing.T) {
+	err := zerrors.ThrowInternal(zerrors.ThrowNotFound(nil, "QUERY-inner", "Errors.NotFound"), "QUERY-outer", "Errors.Internal")
+	if assert.NotNil(t, ErrorByID(err, "QUERY-inner")) {
+		assert.Equal(t, "Errors.NotFound", ErrorByID(err, "QUERY-inner").GetMessage())
+	}
+	if assert.NotNil(t, ErrorByID(err, "QUERY-outer")) {
+		assert.Equal(t, "Errors.Internal", ErrorByID(err, "QUERY-outer").GetMessage())
+	}
+	assert.Nil(t, ErrorByID(err, "QUERY-other"))
+	assert.Nil(t, ErrorByID(nil, "QUERY-outer"))
+}
+
+func TestErrorHasID(t *testing.T) {
+	err := zerrors.ThrowInternal(zerrors.ThrowNotFound(nil, "QUERY-inner", "Errors.NotFound"), "QUERY-outer", "Errors.Internal")
+	assert.True(t, ErrorHasID(err, "QUERY-outer"))
+	assert.True(t, ErrorHasID(err, "QUERY-inner"))
+	assert.False(t, ErrorHasID(err, "QUERY-other"))
+	assert.False(t, ErrorHasID(nil, "QUERY-outer"))
+}
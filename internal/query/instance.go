@@ -1,27 +1,40 @@
 package query
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
 	_ "embed"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/zitadel/logging"
+	"golang.org/x/net/idna"
 	"golang.org/x/text/language"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/zitadel/zitadel/internal/api/authz"
 	"github.com/zitadel/zitadel/internal/api/call"
 	"github.com/zitadel/zitadel/internal/database"
+	"github.com/zitadel/zitadel/internal/domain"
 	"github.com/zitadel/zitadel/internal/eventstore"
 	"github.com/zitadel/zitadel/internal/eventstore/handler/v2"
 	"github.com/zitadel/zitadel/internal/feature"
 	"github.com/zitadel/zitadel/internal/query/projection"
+	instance_repo "github.com/zitadel/zitadel/internal/repository/instance"
+	"github.com/zitadel/zitadel/internal/repository/quota"
 	"github.com/zitadel/zitadel/internal/telemetry/tracing"
 	"github.com/zitadel/zitadel/internal/zerrors"
 )
@@ -106,149 +119,2474 @@ type Instance struct {
 	ConsoleAppID string
 	DefaultLang  language.Tag
 	Domains      []*InstanceDomain
+
+	// AuditLogRetention is the retention of the audit log configured for the instance.
+	// It is nil if the instance uses the default retention of the system.
+	AuditLogRetention *time.Duration
+
+	// DefaultOrgName is only set by [Queries.SearchInstancesWithOrgNames].
+	// It is empty if the default organization does not exist anymore.
+	DefaultOrgName string
+
+	// NeedsAttention and AttentionReasons are only set by [Queries.InstancesNeedingAttention].
+	NeedsAttention   bool
+	AttentionReasons []InstanceAttentionReason
+}
+
+// InstanceAttentionReason explains why an instance needs attention.
+// The projections neither track the setup progress of an instance nor the verification of its domains,
+// so there are no reasons for an incomplete setup or an unverified primary domain.
+// [InstanceAttentionNoPrimaryDomain] covers the missing primary domain instead.
+type InstanceAttentionReason string
+
+const (
+	// InstanceAttentionStale is set if the instance was not changed for longer than [instanceStaleAfter].
+	InstanceAttentionStale InstanceAttentionReason = "stale"
+	// InstanceAttentionNoPrimaryDomain is set if none of the domains of the instance is primary.
+	InstanceAttentionNoPrimaryDomain InstanceAttentionReason = "no_primary_domain"
+)
+
+// instanceStaleAfter is the duration after the last change of an instance after which it is considered stale.
+const instanceStaleAfter = 180 * 24 * time.Hour
+
+// setAttentionReasons computes the reasons why the instance needs attention at now.
+func (i *Instance) setAttentionReasons(now time.Time) {
+	i.AttentionReasons = nil
+	if now.Sub(i.ChangeDate) > instanceStaleAfter {
+		i.AttentionReasons = append(i.AttentionReasons, InstanceAttentionStale)
+	}
+	if !slices.ContainsFunc(i.Domains, func(domain *InstanceDomain) bool { return domain.IsPrimary }) {
+		i.AttentionReasons = append(i.AttentionReasons, InstanceAttentionNoPrimaryDomain)
+	}
+	i.NeedsAttention = len(i.AttentionReasons) > 0
+}
+
+// LogValue implements [slog.LogValuer].
+// Only the ID, name and default language are logged,
+// the internal IDs of the default org, project and console are omitted.
+func (i *Instance) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", i.ID),
+		slog.String("name", i.Name),
+		slog.String("default_language", i.DefaultLang.String()),
+	)
+}
+
+// Age returns the time passed between the creation of the instance and now.
+// It is zero if the creation date is unset or after now, for example because of clock skew.
+func (i *Instance) Age(now time.Time) time.Duration {
+	if i.CreationDate.IsZero() || i.CreationDate.After(now) {
+		return 0
+	}
+	return now.Sub(i.CreationDate)
+}
+
+const (
+	ageDay   = 24 * time.Hour
+	ageMonth = 30 * ageDay
+	ageYear  = 365 * ageDay
+)
+
+// FormatInstanceAge formats the age returned by [Instance.Age] for humans, for example "2y 3m", "3m 12d" or "5d".
+// Months are counted as 30 and years as 365 days, the remainder below a day is dropped.
+func FormatInstanceAge(age time.Duration) string {
+	if age < 0 {
+		age = 0
+	}
+	years, age := age/ageYear, age%ageYear
+	months, age := age/ageMonth, age%ageMonth
+	days := age / ageDay
+	switch {
+	case years > 0:
+		return fmt.Sprintf("%dy %dm", years, months)
+	case months > 0:
+		return fmt.Sprintf("%dm %dd", months, days)
+	default:
+		return fmt.Sprintf("%dd", days)
+	}
+}
+
+// Diff returns the names of the fields which differ between i and other.
+// Domains are compared by value, regardless of their order.
+// A nil instance is treated like an empty one.
+func (i *Instance) Diff(other *Instance) []string {
+	if i == nil {
+		i = new(Instance)
+	}
+	if other == nil {
+		other = new(Instance)
+	}
+	var diff []string
+	add := func(field string, differs bool) {
+		if differs {
+			diff = append(diff, field)
+		}
+	}
+	add("ID", i.ID != other.ID)
+	add("ChangeDate", !i.ChangeDate.Equal(other.ChangeDate))
+	add("CreationDate", !i.CreationDate.Equal(other.CreationDate))
+	add("Sequence", i.Sequence != other.Sequence)
+	add("Name", i.Name != other.Name)
+	add("DefaultOrgID", i.DefaultOrgID != other.DefaultOrgID)
+	add("DefaultOrgName", i.DefaultOrgName != other.DefaultOrgName)
+	add("NeedsAttention", i.NeedsAttention != other.NeedsAttention)
+	add("AttentionReasons", !slices.Equal(i.AttentionReasons, other.AttentionReasons))
+	add("IAMProjectID", i.IAMProjectID != other.IAMProjectID)
+	add("ConsoleID", i.ConsoleID != other.ConsoleID)
+	add("ConsoleAppID", i.ConsoleAppID != other.ConsoleAppID)
+	add("DefaultLang", i.DefaultLang != other.DefaultLang)
+	add("Domains", !equalInstanceDomains(i.Domains, other.Domains))
+	add("AuditLogRetention", (i.AuditLogRetention == nil) != (other.AuditLogRetention == nil) ||
+		i.AuditLogRetention != nil && *i.AuditLogRetention != *other.AuditLogRetention)
+	return diff
+}
+
+func equalInstanceDomains(a, b []*InstanceDomain) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	domains := make(map[string]*InstanceDomain, len(a))
+	for _, domain := range a {
+		domains[domain.Domain] = domain
+	}
+	for _, domain := range b {
+		other, ok := domains[domain.Domain]
+		if !ok ||
+			domain.InstanceID != other.InstanceID ||
+			domain.IsPrimary != other.IsPrimary ||
+			domain.IsGenerated != other.IsGenerated ||
+			domain.Sequence != other.Sequence ||
+			!domain.CreationDate.Equal(other.CreationDate) ||
+			!domain.ChangeDate.Equal(other.ChangeDate) {
+			return false
+		}
+	}
+	return true
+}
+
+type Instances struct {
+	SearchResponse
+	Instances []*Instance
+}
+
+// WriteCSV writes the instances to w as CSV, starting with a header row.
+// The creation date is formatted as RFC 3339.
+// There is no setup done column: the projections do not track the setup progress of an instance,
+// which is also why [Queries.InstancesNeedingAttention] has no reason for an incomplete setup.
+func (i *Instances) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "name", "creation_date", "default_language"}); err != nil {
+		return err
+	}
+	for _, instance := range i.Instances {
+		err := writer.Write([]string{
+			instance.ID,
+			instance.Name,
+			instance.CreationDate.Format(time.RFC3339),
+			instance.DefaultLang.String(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type InstanceSearchQueries struct {
+	SearchRequest
+	Queries []SearchQuery
+
+	// ShuffleSeed orders the instances returned by [Queries.SearchInstances], [Queries.SearchInstancesChan]
+	// and [Queries.StreamInstances] pseudo-randomly.
+	// The same seed always results in the same order, which is useful to spread load in load tests.
+	// Limit and Offset are applied to the shuffled instances, so the pages of a seed are reproducible.
+	ShuffleSeed string
+}
+
+// InstanceQueriesConfig configures the instance queries.
+// It is turned into [QueryOption]s by [InstanceQueriesConfig.Options].
+type InstanceQueriesConfig struct {
+	// CacheRefreshAhead is passed to [WithInstanceCacheRefreshAhead].
+	CacheRefreshAhead time.Duration
+	// MaxResultSize is passed to [WithMaxInstanceResultSize].
+	MaxResultSize uint64
+	// NormalizeNames trims and lower cases the instance names passed to the queries, see [WithInstanceNameNormalizer].
+	NormalizeNames bool
+}
+
+// Options returns the options configured by c.
+// A nil config returns no options.
+func (c *InstanceQueriesConfig) Options() []QueryOption {
+	if c == nil {
+		return nil
+	}
+	opts := []QueryOption{
+		WithInstanceCacheRefreshAhead(c.CacheRefreshAhead),
+		WithMaxInstanceResultSize(c.MaxResultSize),
+	}
+	if c.NormalizeNames {
+		opts = append(opts, WithInstanceNameNormalizer(normalizeInstanceNameLower))
+	}
+	return opts
+}
+
+// normalizeInstanceNameLower is the normalizer used by [InstanceQueriesConfig.NormalizeNames].
+func normalizeInstanceNameLower(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ErrInstanceResultSizeExceeded is wrapped by the error returned from [Queries.SearchInstances]
+// if the requested limit exceeds the maximum set by [WithMaxInstanceResultSize].
+var ErrInstanceResultSizeExceeded = errors.New("instance result size exceeded")
+
+// WithMaxInstanceResultSize caps the amount of instances returned by [Queries.SearchInstances].
+// Searches without a limit are silently limited to n,
+// searches with a limit above n are rejected with [ErrInstanceResultSizeExceeded].
+// 0 disables the cap.
+func WithMaxInstanceResultSize(n uint64) QueryOption {
+	return func(q *Queries) {
+		q.maxInstanceResultSize = n
+	}
+}
+
+// WithInstanceNameNormalizer sets the function which canonicalizes instance names,
+// for example by trimming and lower casing them.
+// It is applied to the names passed to [Queries.InstanceNamesSearchQuery] and [Queries.InstanceNameTaken],
+// the stored names are compared as they are.
+// By default names are used unchanged.
+func WithInstanceNameNormalizer(normalize func(string) string) QueryOption {
+	return func(q *Queries) {
+		q.instanceNameNormalizer = normalize
+	}
+}
+
+// normalizeInstanceName applies the normalizer set by [WithInstanceNameNormalizer] to name.
+func (q *Queries) normalizeInstanceName(name string) string {
+	if q.instanceNameNormalizer == nil {
+		return name
+	}
+	return q.instanceNameNormalizer(name)
+}
+
+// limitInstanceResultSize returns a copy of queries with the limit capped to the configured maximum.
+func (q *Queries) limitInstanceResultSize(queries *InstanceSearchQueries) (*InstanceSearchQueries, error) {
+	if q.maxInstanceResultSize == 0 {
+		return queries, nil
+	}
+	if queries.Limit > q.maxInstanceResultSize {
+		return nil, zerrors.ThrowInvalidArgument(
+			fmt.Errorf("%w: given: %d, allowed: %d", ErrInstanceResultSizeExceeded, queries.Limit, q.maxInstanceResultSize),
+			"QUERY-Wai3o", "Errors.Query.LimitExceeded",
+		)
+	}
+	limited := *queries
+	if limited.Limit == 0 {
+		limited.Limit = q.maxInstanceResultSize
+	}
+	return &limited, nil
+}
+
+func NewInstanceIDsListSearchQuery(ids ...string) (SearchQuery, error) {
+	list := make([]interface{}, len(ids))
+	for i, value := range ids {
+		list[i] = value
+	}
+	return NewListQuery(InstanceColumnID, list, ListIn)
+}
+
+// NewInstanceNamesListSearchQuery matches instances with one of the names, case sensitive.
+func NewInstanceNamesListSearchQuery(names ...string) (SearchQuery, error) {
+	list := make([]interface{}, len(names))
+	for i, value := range names {
+		list[i] = value
+	}
+	return NewListQuery(InstanceColumnName, list, ListIn)
+}
+
+// InstanceNamesSearchQuery is like [NewInstanceNamesListSearchQuery],
+// but normalizes the names with the normalizer set by [WithInstanceNameNormalizer].
+func (q *Queries) InstanceNamesSearchQuery(names ...string) (SearchQuery, error) {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = q.normalizeInstanceName(name)
+	}
+	return NewInstanceNamesListSearchQuery(normalized...)
+}
+
+// NewInstanceNamesListIgnoreCaseSearchQuery is like [NewInstanceNamesListSearchQuery], but ignores the case of the names.
+func NewInstanceNamesListIgnoreCaseSearchQuery(names ...string) (SearchQuery, error) {
+	queries := make([]SearchQuery, len(names))
+	for i, name := range names {
+		query, err := NewTextQuery(InstanceColumnName, name, TextEqualsIgnoreCase)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = query
+	}
+	return NewOrQuery(queries...)
+}
+
+func NewInstanceDomainsListSearchQuery(domains ...string) (SearchQuery, error) {
+	list := make([]interface{}, len(domains))
+	for i, value := range domains {
+		list[i] = value
+	}
+
+	return NewListQuery(InstanceDomainDomainCol, list, ListIn)
+}
+
+// NewInstanceHostTLDSearchQuery matches instances with a domain ending with one of the tlds, for example "com" or ".io".
+// A tld is compared to the end of the domain, not to a public suffix list.
+// Therefore "uk" matches "example.co.uk", and multi-label suffixes like "co.uk" must be passed explicitly.
+func NewInstanceHostTLDSearchQuery(tlds ...string) (SearchQuery, error) {
+	if len(tlds) == 0 {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-ahW3i", "Errors.Query.InvalidRequest")
+	}
+	queries := make([]SearchQuery, len(tlds))
+	for i, tld := range tlds {
+		tld = strings.ToLower(strings.Trim(tld, "."))
+		if tld == "" {
+			return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Pee6o", "Errors.Query.InvalidRequest")
+		}
+		query, err := NewTextQuery(InstanceDomainDomainCol, "."+tld, TextEndsWith)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = query
+	}
+	return NewOrQuery(queries...)
+}
+
+// instanceIDPrefixMinLength is the minimal length of the prefix passed to [NewInstanceIDPrefixSearchQuery].
+const instanceIDPrefixMinLength = 4
+
+// NewInstanceIDPrefixSearchQuery matches instances whose ID starts with prefix, for example a truncated ID from a log line.
+// Prefixes shorter than 4 characters are rejected, so they do not match almost every instance.
+func NewInstanceIDPrefixSearchQuery(prefix string) (SearchQuery, error) {
+	if len(prefix) < instanceIDPrefixMinLength {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Aek3u", "Errors.Query.InvalidRequest")
+	}
+	return NewTextQuery(InstanceColumnID, prefix, TextStartsWith)
+}
+
+// ValidLanguageTag parses s into a language tag.
+// Unlike [language.Make], which silently returns an undefined tag,
+// it rejects malformed input and well-formed tags with unknown subtags with an invalid argument error.
+func ValidLanguageTag(s string) (language.Tag, error) {
+	tag, err := language.Parse(s)
+	if err != nil {
+		return language.Und, zerrors.ThrowInvalidArgument(err, "QUERY-aeD0v", "Errors.Language.NotParsed")
+	}
+	if err = domain.LanguageIsDefined(tag); err != nil {
+		return language.Und, err
+	}
+	return tag, nil
+}
+
+// NewInstanceDefaultLanguageSearchQuery matches instances with lang as default language.
+// lang is validated using [ValidLanguageTag].
+func NewInstanceDefaultLanguageSearchQuery(lang string) (SearchQuery, error) {
+	tag, err := ValidLanguageTag(lang)
+	if err != nil {
+		return nil, err
+	}
+	return NewTextQuery(InstanceColumnDefaultLanguage, tag.String(), TextEquals)
+}
+
+// NewInstanceStaleSearchQuery matches instances which did not change since olderThan.
+// Such instances might have a stuck projection.
+func NewInstanceStaleSearchQuery(olderThan time.Time) (SearchQuery, error) {
+	return NewTimestampQuery(InstanceColumnChangeDate, olderThan, TimestampLess)
+}
+
+// NewInstanceOnlyGeneratedDomainSearchQuery matches instances without any custom domain,
+// which means only the generated domain is assigned.
+func NewInstanceOnlyGeneratedDomainSearchQuery() (SearchQuery, error) {
+	customDomain, err := NewInstanceDomainGeneratedSearchQuery(false)
+	if err != nil {
+		return nil, err
+	}
+	withCustomDomain, err := NewSubSelect(InstanceDomainInstanceIDCol, []SearchQuery{customDomain})
+	if err != nil {
+		return nil, err
+	}
+	hasCustomDomain, err := NewListQuery(InstanceColumnID, withCustomDomain, ListIn)
+	if err != nil {
+		return nil, err
+	}
+	return NewNotQuery(hasCustomDomain)
+}
+
+// NewInstanceHasCustomLoginPolicySearchQuery matches instances with at least one organization
+// which has its own login policy instead of the default login policy of the instance, if has is true.
+// If has is false, it matches instances where all organizations use the default login policy.
+func NewInstanceHasCustomLoginPolicySearchQuery(has bool) (SearchQuery, error) {
+	customPolicy, err := NewBoolQuery(LoginPolicyColumnIsDefault, false)
+	if err != nil {
+		return nil, err
+	}
+	ownerExists, err := NewBoolQuery(LoginPolicyColumnOwnerRemoved, false)
+	if err != nil {
+		return nil, err
+	}
+	withCustomPolicy, err := NewSubSelect(LoginPolicyColumnInstanceID, []SearchQuery{customPolicy, ownerExists})
+	if err != nil {
+		return nil, err
+	}
+	hasCustomPolicy, err := NewListQuery(InstanceColumnID, withCustomPolicy, ListIn)
+	if err != nil {
+		return nil, err
+	}
+	if has {
+		return hasCustomPolicy, nil
+	}
+	return NewNotQuery(hasCustomPolicy)
+}
+
+// newInstanceDefaultLoginPolicySearchQuery matches instances whose default login policy has value in col.
+// Booleans are compared by a [BoolQuery], other values by a [NumberQuery].
+func newInstanceDefaultLoginPolicySearchQuery(col Column, value any) (SearchQuery, error) {
+	defaultPolicy, err := NewBoolQuery(LoginPolicyColumnIsDefault, true)
+	if err != nil {
+		return nil, err
+	}
+	var policyQuery SearchQuery
+	if b, ok := value.(bool); ok {
+		policyQuery, err = NewBoolQuery(col, b)
+	} else {
+		policyQuery, err = NewNumberQuery(col, value, NumberEquals)
+	}
+	if err != nil {
+		return nil, err
+	}
+	withPolicy, err := NewSubSelect(LoginPolicyColumnInstanceID, []SearchQuery{defaultPolicy, policyQuery})
+	if err != nil {
+		return nil, err
+	}
+	return NewListQuery(InstanceColumnID, withPolicy, ListIn)
+}
+
+// NewInstanceMFAEnforcedSearchQuery matches instances by the force MFA flag of their default login policy.
+// Login policies of organizations are not considered, they might enforce MFA even if the instance does not.
+func NewInstanceMFAEnforcedSearchQuery(enforced bool) (SearchQuery, error) {
+	return newInstanceDefaultLoginPolicySearchQuery(LoginPolicyColumnForceMFA, enforced)
+}
+
+// NewInstanceSSOEnabledSearchQuery matches instances by whether their default login policy allows login with external identity providers.
+// Login policies of organizations are not considered.
+func NewInstanceSSOEnabledSearchQuery(enabled bool) (SearchQuery, error) {
+	return newInstanceDefaultLoginPolicySearchQuery(LoginPolicyColumnAllowExternalIDPs, enabled)
+}
+
+// NewInstanceOpenRegistrationSearchQuery matches instances by whether their default login policy allows users to register themselves.
+// Login policies of organizations are not considered.
+func NewInstanceOpenRegistrationSearchQuery(open bool) (SearchQuery, error) {
+	return newInstanceDefaultLoginPolicySearchQuery(LoginPolicyColumnAllowRegister, open)
+}
+
+// NewInstanceAdminEmailDomainSearchQuery matches instances with at least one instance owner whose email is on emailDomain, for example "acme.com".
+// The initial admin of an instance is not recorded, so all human users with the [domain.RoleIAMOwner] role are considered.
+func NewInstanceAdminEmailDomainSearchQuery(emailDomain string) (SearchQuery, error) {
+	emailDomain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(emailDomain)), "@")
+	if emailDomain == "" {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Xee4o", "Errors.Query.InvalidRequest")
+	}
+	emailQuery, err := NewTextQuery(HumanEmailCol, "@"+emailDomain, TextEndsWithIgnoreCase)
+	if err != nil {
+		return nil, err
+	}
+	users, err := NewSubSelect(HumanUserIDCol, []SearchQuery{emailQuery})
+	if err != nil {
+		return nil, err
+	}
+	userQuery, err := NewListQuery(InstanceMemberUserID, users, ListIn)
+	if err != nil {
+		return nil, err
+	}
+	ownerQuery, err := NewTextQuery(InstanceMemberRoles, domain.RoleIAMOwner, TextListContains)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := NewSubSelect(InstanceMemberInstanceID, []SearchQuery{ownerQuery, userQuery})
+	if err != nil {
+		return nil, err
+	}
+	return NewListQuery(InstanceColumnID, owners, ListIn)
+}
+
+// NewInstancePasswordlessAllowedSearchQuery matches instances by whether their default login policy allows passwordless login.
+// Login policies of organizations are not considered.
+func NewInstancePasswordlessAllowedSearchQuery(allowed bool) (SearchQuery, error) {
+	passwordlessType := domain.PasswordlessTypeNotAllowed
+	if allowed {
+		passwordlessType = domain.PasswordlessTypeAllowed
+	}
+	return newInstanceDefaultLoginPolicySearchQuery(LoginPolicyColumnPasswordlessType, passwordlessType)
+}
+
+// NewInstanceAuditRetentionSearchQuery matches instances by comparing their audit log retention in days with days.
+// Instances without an own audit log retention use the default retention of the system and are never matched.
+func NewInstanceAuditRetentionSearchQuery(comparison NumberComparison, days int) (SearchQuery, error) {
+	retention, err := NewNumberQuery(LimitsColumnAuditLogRetention, time.Duration(days)*24*time.Hour, comparison)
+	if err != nil {
+		return nil, err
+	}
+	withRetention, err := NewSubSelect(LimitsColumnInstanceID, []SearchQuery{retention})
+	if err != nil {
+		return nil, err
+	}
+	return NewListQuery(InstanceColumnID, withRetention, ListIn)
+}
+
+// instanceIDPTypes maps the names accepted by [NewInstanceHasIDPSearchQuery] to the type of the identity provider.
+var instanceIDPTypes = map[string]domain.IDPType{
+	"oidc":               domain.IDPTypeOIDC,
+	"jwt":                domain.IDPTypeJWT,
+	"oauth":              domain.IDPTypeOAuth,
+	"ldap":               domain.IDPTypeLDAP,
+	"azure_ad":           domain.IDPTypeAzureAD,
+	"github":             domain.IDPTypeGitHub,
+	"github_enterprise":  domain.IDPTypeGitHubEnterprise,
+	"gitlab":             domain.IDPTypeGitLab,
+	"gitlab_self_hosted": domain.IDPTypeGitLabSelfHosted,
+	"google":             domain.IDPTypeGoogle,
+	"apple":              domain.IDPTypeApple,
+	"saml":               domain.IDPTypeSAML,
+}
+
+// NewInstanceHasIDPSearchQuery matches instances with at least one identity provider of idpType, for example "saml".
+// Identity providers of the instance and of its organizations are considered, except the ones of removed organizations.
+func NewInstanceHasIDPSearchQuery(idpType string) (SearchQuery, error) {
+	typ, ok := instanceIDPTypes[strings.ToLower(idpType)]
+	if !ok {
+		return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("unknown idp type %q", idpType), "QUERY-Fah3k", "Errors.Query.InvalidRequest")
+	}
+	typeQuery, err := NewNumberQuery(IDPTemplateTypeCol, typ, NumberEquals)
+	if err != nil {
+		return nil, err
+	}
+	ownerNotRemoved, err := NewBoolQuery(IDPTemplateOwnerRemovedCol, false)
+	if err != nil {
+		return nil, err
+	}
+	withIDP, err := NewSubSelect(IDPTemplateInstanceIDCol, []SearchQuery{typeQuery, ownerNotRemoved})
+	if err != nil {
+		return nil, err
+	}
+	return NewListQuery(InstanceColumnID, withIDP, ListIn)
+}
+
+// instanceQuotaUnits maps the names accepted by [NewInstanceOverQuotaSearchQuery] to the unit of the quota.
+var instanceQuotaUnits = map[string]quota.Unit{
+	"requests.all.authenticated": quota.RequestsAllAuthenticated,
+	"actions.all.runs.seconds":   quota.ActionsAllRunsSeconds,
+}
+
+// NewInstanceOverQuotaSearchQuery matches instances which used up their quota of quotaType, for example "requests.all.authenticated", in the current period.
+// Instances whose usage reached the amount exactly are matched as well, as no further usage is permitted.
+func NewInstanceOverQuotaSearchQuery(quotaType string) (SearchQuery, error) {
+	unit, ok := instanceQuotaUnits[strings.ToLower(quotaType)]
+	if !ok {
+		return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("unknown quota type %q", quotaType), "QUERY-Ke7ai", "Errors.Query.InvalidRequest")
+	}
+	return &instanceOverQuotaQuery{unit: unit}, nil
+}
+
+// FilterNode is a node of a filter tree on instances, for example decoded from the JSON of an API request.
+// Exactly one of And, Or and Leaf must be set, And and Or must not be empty.
+// The tree is compiled into a [SearchQuery] of [InstanceSearchQueries] by [FilterNode.SearchQuery].
+type FilterNode struct {
+	And  []*FilterNode `json:"and,omitempty"`
+	Or   []*FilterNode `json:"or,omitempty"`
+	Leaf *FilterLeaf   `json:"leaf,omitempty"`
+}
+
+// FilterLeaf compares the Column of an instance with Value using Operator.
+// Column must be a key of [instanceFilterColumns] and Operator a key of [instanceFilterOperators].
+type FilterLeaf struct {
+	Column   string `json:"column"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// maxFilterNodeDepth limits the nesting of a [FilterNode] tree built from user input.
+const maxFilterNodeDepth = 8
+
+// instanceFilterColumns are the columns which can be used in a [FilterLeaf] and by [NewInstanceColumnSearchQuery].
+var instanceFilterColumns = map[string]Column{
+	"id":               InstanceColumnID,
+	"name":             InstanceColumnName,
+	"default_language": InstanceColumnDefaultLanguage,
+	"domain":           InstanceDomainDomainCol,
+}
+
+// instanceFilterOperators are the operators which can be used in a [FilterLeaf].
+var instanceFilterOperators = map[string]TextComparison{
+	"equals":                  TextEquals,
+	"not_equals":              TextNotEquals,
+	"equals_ignore_case":      TextEqualsIgnoreCase,
+	"starts_with":             TextStartsWith,
+	"starts_with_ignore_case": TextStartsWithIgnoreCase,
+	"ends_with":               TextEndsWith,
+	"ends_with_ignore_case":   TextEndsWithIgnoreCase,
+	"contains":                TextContains,
+	"contains_ignore_case":    TextContainsIgnoreCase,
+}
+
+// SearchQuery compiles the tree into a search query.
+// Unknown columns and operators, nodes without or with more than one variant
+// and trees nested deeper than [maxFilterNodeDepth] are rejected.
+func (n *FilterNode) SearchQuery() (SearchQuery, error) {
+	return n.searchQuery(1)
+}
+
+func (n *FilterNode) searchQuery(depth int) (SearchQuery, error) {
+	if depth > maxFilterNodeDepth {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Eex5o", "Errors.Query.InvalidRequest")
+	}
+	if n == nil || countTrue(len(n.And) > 0, len(n.Or) > 0, n.Leaf != nil) != 1 {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-ooY4u", "Errors.Query.InvalidRequest")
+	}
+	if n.Leaf != nil {
+		return n.Leaf.searchQuery()
+	}
+	children := n.And
+	if len(n.Or) > 0 {
+		children = n.Or
+	}
+	queries := make([]SearchQuery, len(children))
+	for i, child := range children {
+		query, err := child.searchQuery(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = query
+	}
+	if len(n.Or) > 0 {
+		return NewOrQuery(queries...)
+	}
+	return NewAndQuery(queries...)
+}
+
+func (l *FilterLeaf) searchQuery() (SearchQuery, error) {
+	operator, ok := instanceFilterOperators[l.Operator]
+	if !ok {
+		return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("unknown operator %q", l.Operator), "QUERY-Iey3r", "Errors.Query.InvalidRequest")
+	}
+	return NewInstanceColumnSearchQuery(l.Column, operator, l.Value)
+}
+
+// NewInstanceColumnSearchQuery compares the instance column with columnName to value,
+// for example with a column name passed at runtime.
+// Only the columns of [instanceFilterColumns] are allowed, other names are rejected with an invalid argument error.
+func NewInstanceColumnSearchQuery(columnName string, comparison TextComparison, value string) (SearchQuery, error) {
+	column, ok := instanceFilterColumns[columnName]
+	if !ok {
+		return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("unknown column %q", columnName), "QUERY-Wah7i", "Errors.Query.InvalidRequest")
+	}
+	return NewTextQuery(column, value, comparison)
+}
+
+func countTrue(values ...bool) (n int) {
+	for _, value := range values {
+		if value {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *InstanceSearchQueries) toQuery(query sq.SelectBuilder) sq.SelectBuilder {
+	query = q.SearchRequest.toQuery(query)
+	for _, q := range q.Queries {
+		query = q.toQuery(query)
+	}
+	return query
+}
+
+// Validate checks the queries and the pagination before they are sent to the database.
+// For example an empty list of instance ids would not match any instance and is rejected.
+func (q *InstanceSearchQueries) Validate() error {
+	if q == nil {
+		return nil
+	}
+	if q.Limit > math.MaxInt64 || q.Offset > math.MaxInt64 {
+		return zerrors.ThrowInvalidArgument(
+			fmt.Errorf("limit %d and offset %d must not exceed %d", q.Limit, q.Offset, uint64(math.MaxInt64)),
+			"QUERY-ieH6u", "Errors.Query.InvalidRequest",
+		)
+	}
+	for i, query := range q.Queries {
+		if err := validateSearchQuery(query); err != nil {
+			return zerrors.ThrowInvalidArgument(fmt.Errorf("query %d: %w", i, err), "QUERY-Ohgh4", "Errors.Query.InvalidRequest")
+		}
+	}
+	return nil
+}
+
+// instanceShuffleColumn is the alias of the hash the instances are shuffled by.
+const instanceShuffleColumn = "shuffle_key"
+
+// shuffle orders the filter and the query returned by [prepareInstancesQuery] by the hash of the instance id and the seed.
+// The filter is ordered before its limit and offset are applied, the query keeps the order of the filter.
+// The rows of an instance stay consecutive, as they have the same hash.
+func (q *InstanceSearchQueries) shuffle(filter sq.SelectBuilder, query func(sq.SelectBuilder) sq.SelectBuilder) sq.SelectBuilder {
+	if q.ShuffleSeed == "" {
+		return query(filter)
+	}
+	filter = filter.
+		Column("md5("+InstanceColumnID.identifier()+" || ?) AS "+instanceShuffleColumn, q.ShuffleSeed).
+		OrderBy(instanceShuffleColumn)
+	return query(filter).OrderBy(InstancesFilterTableAlias + "." + instanceShuffleColumn)
+}
+
+func (q *Queries) SearchInstances(ctx context.Context, queries *InstanceSearchQueries) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	if err = q.awaitConsistency(ctx); err != nil {
+		return nil, err
+	}
+	return q.searchInstances(ctx, queries, prepareInstancesQuery)
+}
+
+// InstancesNeedingAttention returns all instances which need attention, with NeedsAttention and AttentionReasons set.
+// Healthy instances are omitted. The result is not capped by [WithMaxInstanceResultSize].
+func (q *Queries) InstancesNeedingAttention(ctx context.Context) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instances = new(Instances)
+	now := time.Now()
+	err = q.StreamInstances(ctx, &InstanceSearchQueries{}, true, func(instance *Instance) error {
+		instance.setAttentionReasons(now)
+		if instance.NeedsAttention {
+			instances.Instances = append(instances.Instances, instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	instances.Count = uint64(len(instances.Instances))
+	return instances, nil
+}
+
+// InstancesByPrimaryDomain returns all instances keyed by their primary domain.
+// Instances without a primary domain are omitted.
+// The instances are streamed, so the result is not capped by [WithMaxInstanceResultSize].
+func (q *Queries) InstancesByPrimaryDomain(ctx context.Context) (byDomain map[string]*Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	byDomain = make(map[string]*Instance)
+	err = q.StreamInstances(ctx, &InstanceSearchQueries{}, true, func(instance *Instance) error {
+		i := slices.IndexFunc(instance.Domains, func(domain *InstanceDomain) bool { return domain.IsPrimary })
+		if i < 0 {
+			logging.WithFields("instance_id", instance.ID).Info("instance without primary domain omitted")
+			return nil
+		}
+		byDomain[instance.Domains[i].Domain] = instance
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return byDomain, nil
+}
+
+// instanceHealthPenalties are the points deducted from the health score of 100 per reason an instance needs attention.
+var instanceHealthPenalties = map[InstanceAttentionReason]int{
+	InstanceAttentionNoPrimaryDomain: 40,
+	InstanceAttentionStale:           30,
+}
+
+// instanceHealthPenaltyBrokenConsole is deducted from the health score if the console app of the instance has no redirect URIs.
+const instanceHealthPenaltyBrokenConsole = 30
+
+// InstanceHealthScores returns a health score between 0 and 100 of all instances by their id.
+// A healthy instance scores 100, the points of every failed check are deducted:
+//   - 40 if none of the domains is primary
+//   - 30 if the console app has no redirect URIs, see [Queries.InstancesWithBrokenConsole]
+//   - 30 if the instance was not changed for 180 days, see [InstanceAttentionStale]
+//
+// The scores are computed over all instances, they are not capped by [WithMaxInstanceResultSize].
+func (q *Queries) InstanceHealthScores(ctx context.Context) (scores map[string]int, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	brokenConsole, err := q.InstancesWithBrokenConsole(ctx)
+	if err != nil {
+		return nil, err
+	}
+	broken := make(map[string]bool, len(brokenConsole.Instances))
+	for _, instance := range brokenConsole.Instances {
+		broken[instance.ID] = true
+	}
+
+	scores = make(map[string]int)
+	now := time.Now()
+	err = q.StreamInstances(ctx, &InstanceSearchQueries{}, false, func(instance *Instance) error {
+		instance.setAttentionReasons(now)
+		score := 100
+		for _, reason := range instance.AttentionReasons {
+			score -= instanceHealthPenalties[reason]
+		}
+		if broken[instance.ID] {
+			score -= instanceHealthPenaltyBrokenConsole
+		}
+		scores[instance.ID] = max(score, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// SearchInstancesWithOrgNames is like [Queries.SearchInstances],
+// but additionally resolves the name of the default organization of each instance.
+func (q *Queries) SearchInstancesWithOrgNames(ctx context.Context, queries *InstanceSearchQueries) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	return q.searchInstances(ctx, queries, prepareInstancesWithOrgNameQuery)
+}
+
+// InstancesChangedSince returns the instances which changed after the time in since for their id
+// and all instances which are not contained in since.
+// An empty since returns all instances.
+func (q *Queries) InstancesChangedSince(ctx context.Context, since map[string]time.Time) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	queries := new(InstanceSearchQueries)
+	if len(since) > 0 {
+		changedQuery, err := newInstanceChangedSinceSearchQuery(since)
+		if err != nil {
+			return nil, err
+		}
+		queries.Queries = []SearchQuery{changedQuery}
+	}
+	return q.searchInstances(ctx, queries, prepareInstancesQuery)
+}
+
+func newInstanceChangedSinceSearchQuery(since map[string]time.Time) (SearchQuery, error) {
+	ids := make([]string, 0, len(since))
+	for id := range since {
+		ids = append(ids, id)
+	}
+	// sorted for a stable statement
+	slices.Sort(ids)
+
+	queries := make([]SearchQuery, 0, len(ids)+1)
+	idsQuery, err := NewInstanceIDsListSearchQuery(ids...)
+	if err != nil {
+		return nil, err
+	}
+	unknownQuery, err := NewNotQuery(idsQuery)
+	if err != nil {
+		return nil, err
+	}
+	queries = append(queries, unknownQuery)
+	for _, id := range ids {
+		idQuery, err := NewTextQuery(InstanceColumnID, id, TextEquals)
+		if err != nil {
+			return nil, err
+		}
+		changedQuery, err := NewTimestampQuery(InstanceColumnChangeDate, since[id], TimestampGreater)
+		if err != nil {
+			return nil, err
+		}
+		andQuery, err := NewAndQuery(idQuery, changedQuery)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, andQuery)
+	}
+	return NewOrQuery(queries...)
+}
+
+func (q *Queries) searchInstances(
+	ctx context.Context,
+	queries *InstanceSearchQueries,
+	prepare func(context.Context, prepareDatabase) (sq.SelectBuilder, func(sq.SelectBuilder) sq.SelectBuilder, func(*sql.Rows) (*Instances, error)),
+) (instances *Instances, err error) {
+	if err = queries.Validate(); err != nil {
+		return nil, err
+	}
+	queries, err = q.limitInstanceResultSize(queries)
+	if err != nil {
+		return nil, err
+	}
+	filter, query, scan := prepare(ctx, q.client)
+	stmt, args, err := queries.shuffle(queries.toQuery(filter), query).ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-M9fow", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instances, err = scan(rows)
+		return err
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-3j98f", "Errors.Internal")
+	}
+	return instances, err
+}
+
+// SearchInstancesAfterName returns up to limit instances ordered by name and id,
+// starting after the instance with afterName and afterID.
+// Passing the name and id of the last instance of a page returns the next page,
+// which unlike offset pagination is not shifted by instances added in the meantime.
+// Empty afterName and afterID return the first page.
+// The count is the number of instances after the given position.
+func (q *Queries) SearchInstancesAfterName(ctx context.Context, afterName, afterID string, limit uint64) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	queries, err := q.limitInstanceResultSize(&InstanceSearchQueries{SearchRequest: SearchRequest{Limit: limit}})
+	if err != nil {
+		return nil, err
+	}
+	filter := sq.Select(
+		InstanceColumnID.identifier(),
+		InstanceColumnName.identifier(),
+		countColumn.identifier(),
+	).From(instanceTable.identifier())
+	if afterName != "" || afterID != "" {
+		filter = filter.Where(
+			"("+InstanceColumnName.identifier()+", "+InstanceColumnID.identifier()+") > (?, ?)",
+			afterName, afterID,
+		)
+	}
+	filter = filter.OrderBy(InstanceColumnName.identifier(), InstanceColumnID.identifier())
+
+	_, query, scan := prepareInstancesQuery(ctx, q.client)
+	stmt, args, err := query(queries.SearchRequest.toQuery(filter)).
+		OrderBy(
+			InstancesFilterTableAlias+"."+projection.InstanceColumnName,
+			InstancesFilterTableAlias+"."+projection.InstanceColumnID,
+		).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-Xoo4i", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instances, err = scan(rows)
+		return err
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-ieP5a", "Errors.Internal")
+	}
+	return instances, nil
+}
+
+// InstancesChangedBetween returns up to limit instances with a sequence greater than fromSeq and up to toSeq, ordered by sequence.
+// It allows to replicate instances incrementally by passing the sequence of the last returned instance as the next fromSeq.
+func (q *Queries) InstancesChangedBetween(ctx context.Context, fromSeq, toSeq, limit uint64) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	if fromSeq > toSeq {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-iu3Ae", "Errors.Query.InvalidRequest")
+	}
+	queries, err := q.limitInstanceResultSize(&InstanceSearchQueries{SearchRequest: SearchRequest{Limit: limit}})
+	if err != nil {
+		return nil, err
+	}
+	filter := sq.Select(
+		InstanceColumnID.identifier(),
+		InstanceColumnSequence.identifier(),
+		countColumn.identifier(),
+	).From(instanceTable.identifier()).
+		Where(sq.And{
+			sq.Gt{InstanceColumnSequence.identifier(): fromSeq},
+			sq.LtOrEq{InstanceColumnSequence.identifier(): toSeq},
+		}).
+		OrderBy(InstanceColumnSequence.identifier(), InstanceColumnID.identifier())
+
+	_, query, scan := prepareInstancesQuery(ctx, q.client)
+	stmt, args, err := query(queries.SearchRequest.toQuery(filter)).
+		OrderBy(
+			InstancesFilterTableAlias+"."+projection.InstanceColumnSequence,
+			InstancesFilterTableAlias+"."+projection.InstanceColumnID,
+		).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-Queo8", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instances, err = scan(rows)
+		return err
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-xah6E", "Errors.Internal")
+	}
+	return instances, nil
+}
+
+// RecentInstances returns the n most recently created instances, newest first.
+// A zero n returns all instances, bounded by the configured maximum result size.
+func (q *Queries) RecentInstances(ctx context.Context, n uint64) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	queries, err := q.limitInstanceResultSize(&InstanceSearchQueries{SearchRequest: SearchRequest{Limit: n}})
+	if err != nil {
+		return nil, err
+	}
+	filter := sq.Select(
+		InstanceColumnID.identifier(),
+		InstanceColumnCreationDate.identifier(),
+		countColumn.identifier(),
+	).From(instanceTable.identifier()).
+		OrderBy(InstanceColumnCreationDate.identifier()+" DESC", InstanceColumnID.identifier())
+
+	_, query, scan := prepareInstancesQuery(ctx, q.client)
+	stmt, args, err := query(queries.SearchRequest.toQuery(filter)).
+		OrderBy(
+			InstancesFilterTableAlias+"."+projection.InstanceColumnCreationDate+" DESC",
+			InstancesFilterTableAlias+"."+projection.InstanceColumnID,
+		).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-Ahs3e", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instances, err = scan(rows)
+		return err
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Vae9i", "Errors.Internal")
+	}
+	return instances, nil
+}
+
+// InstancesWithBrokenConsole returns the instances whose console app has no redirect URIs,
+// which breaks the login to the console.
+// Console apps without OIDC configuration are returned as well.
+// As an internal report it returns all of them, the result is not capped by [WithMaxInstanceResultSize].
+func (q *Queries) InstancesWithBrokenConsole(ctx context.Context) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	filter := sq.Select(
+		InstanceColumnID.identifier(),
+		countColumn.identifier(),
+	).From(instanceTable.identifier()).
+		LeftJoin(join(AppOIDCConfigColumnAppID, InstanceColumnConsoleAppID)).
+		Where("COALESCE(CARDINALITY(" + AppOIDCConfigColumnRedirectUris.identifier() + "), 0) = 0")
+
+	_, query, scan := prepareInstancesQuery(ctx, q.client)
+	stmt, args, err := query(filter).ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-Bai5e", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instances, err = scan(rows)
+		return err
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Nee3o", "Errors.Internal")
+	}
+	return instances, nil
+}
+
+// SearchInstancesChan is like [Queries.SearchInstances], but sends the instances on the returned data channel
+// as they are scanned. The data channel is closed when all instances are sent or an error occurred.
+// The error, if any, is sent on the error channel, which is closed afterwards.
+// Invalid queries and limits exceeding [WithMaxInstanceResultSize] are reported on the error channel before the query is started.
+//
+// The query holds a database connection until the data channel is closed.
+// A consumer which stops reading before must cancel ctx, the producer then stops, releases the connection
+// and sends the error of ctx on the error channel.
+func (q *Queries) SearchInstancesChan(ctx context.Context, queries *InstanceSearchQueries) (<-chan *Instance, <-chan error) {
+	instances := make(chan *Instance)
+	errs := make(chan error, 1)
+
+	err := queries.Validate()
+	if err == nil {
+		queries, err = q.limitInstanceResultSize(queries)
+	}
+	if err != nil {
+		errs <- err
+		close(errs)
+		close(instances)
+		return instances, errs
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(instances)
+
+		var err error
+		ctx, span := tracing.NewSpan(ctx)
+		defer func() { span.EndWithError(err) }()
+
+		filter, query, _ := prepareInstancesQuery(ctx, q.client)
+		stmt, args, err := queries.shuffle(queries.toQuery(filter), query).ToSql()
+		if err != nil {
+			errs <- zerrors.ThrowInvalidArgument(err, "QUERY-Ahb2o", "Errors.Query.SQLStatement")
+			return
+		}
+
+		err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+			_, err := scanInstanceRows(rows, false, allocateInstance, func(instance *Instance) error {
+				select {
+				case instances <- instance:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			return err
+		}, stmt, args...)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			errs <- err
+			return
+		}
+		if err != nil {
+			errs <- zerrors.ThrowInternal(err, "QUERY-eeZ5u", "Errors.Internal")
+		}
+	}()
+
+	return instances, errs
+}
+
+// StreamInstances calls fn for every instance matching queries, without collecting them into a slice.
+// Iteration stops at the first error returned by fn, which is then returned.
+// The queries are validated by [InstanceSearchQueries.Validate], but unlike [Queries.SearchInstances]
+// the amount of instances is not capped by [WithMaxInstanceResultSize].
+//
+// Unless allocate is set, the same *Instance is passed to every call of fn
+// and overwritten by the next instance after fn returns, including the backing array of its Domains.
+// fn must not retain the instance, its Domains slice, or pass them to other go routines.
+// Copy the required fields instead, or set allocate to receive a new *Instance on every call.
+func (q *Queries) StreamInstances(ctx context.Context, queries *InstanceSearchQueries, allocate bool, fn func(*Instance) error) (err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	if err = queries.Validate(); err != nil {
+		return err
+	}
+	filter, query, _ := prepareInstancesQuery(ctx, q.client)
+	stmt, args, err := queries.shuffle(queries.toQuery(filter), query).ToSql()
+	if err != nil {
+		return zerrors.ThrowInvalidArgument(err, "QUERY-eiN1e", "Errors.Query.SQLStatement")
+	}
+
+	newInstance := allocateInstance
+	if !allocate {
+		instance := new(Instance)
+		newInstance = func() *Instance { return instance }
+	}
+	var fnErr error
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		_, err := scanInstanceRows(rows, false, newInstance, func(instance *Instance) error {
+			fnErr = fn(instance)
+			return fnErr
+		})
+		return err
+	}, stmt, args...)
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return zerrors.ThrowInternal(err, "QUERY-Ue9ai", "Errors.Internal")
+	}
+	return nil
+}
+
+// ndjsonFlushInterval is the amount of lines after which [Queries.ExportInstancesNDJSON] flushes its buffer.
+const ndjsonFlushInterval = 100
+
+// instanceNDJSON is a line written by [Queries.ExportInstancesNDJSON].
+type instanceNDJSON struct {
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	CreationDate    time.Time `json:"creation_date"`
+	ChangeDate      time.Time `json:"change_date"`
+	Sequence        uint64    `json:"sequence"`
+	DefaultLanguage string    `json:"default_language"`
+	Domains         []string  `json:"domains"`
+}
+
+// ExportInstancesNDJSON writes the instances matching queries to w as newline delimited JSON, one instance per line.
+// The instances are streamed by [Queries.StreamInstances] and w is flushed every [ndjsonFlushInterval] lines.
+// The amount of exported instances is returned, also if the export is aborted by an error or the cancellation of ctx.
+func (q *Queries) ExportInstancesNDJSON(ctx context.Context, queries *InstanceSearchQueries, w io.Writer) (count uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	line := new(instanceNDJSON)
+	err = q.StreamInstances(ctx, queries, false, func(instance *Instance) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		*line = instanceNDJSON{
+			ID:              instance.ID,
+			Name:            instance.Name,
+			CreationDate:    instance.CreationDate,
+			ChangeDate:      instance.ChangeDate,
+			Sequence:        instance.Sequence,
+			DefaultLanguage: instance.DefaultLang.String(),
+			Domains:         make([]string, len(instance.Domains)),
+		}
+		for i, domain := range instance.Domains {
+			line.Domains[i] = domain.Domain
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		count++
+		if count%ndjsonFlushInterval == 0 {
+			return buf.Flush()
+		}
+		return nil
+	})
+	if flushErr := buf.Flush(); err == nil {
+		err = flushErr
+	}
+	return count, err
+}
+
+func allocateInstance() *Instance {
+	return new(Instance)
+}
+
+type instanceContextKey struct{}
+
+// instanceContext holds the instance resolved by [Queries.Instance] for the lifetime of a context.
+type instanceContext struct {
+	mu       sync.Mutex
+	instance *Instance
+}
+
+// NewInstanceContext returns a context in which [Queries.Instance] keeps the resolved instance,
+// so subsequent calls with the same or a derived context reuse it instead of querying the database.
+// It is meant to be called once per request, for example by a middleware.
+func NewInstanceContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, instanceContextKey{}, new(instanceContext))
+}
+
+// InstanceFromContext returns the instance resolved by [Queries.Instance]
+// if the context was created by [NewInstanceContext].
+func InstanceFromContext(ctx context.Context) (*Instance, bool) {
+	holder, ok := ctx.Value(instanceContextKey{}).(*instanceContext)
+	if !ok {
+		return nil, false
+	}
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	return holder.instance, holder.instance != nil
+}
+
+func setInstanceContext(ctx context.Context, instance *Instance) {
+	holder, ok := ctx.Value(instanceContextKey{}).(*instanceContext)
+	if !ok {
+		return
+	}
+	holder.mu.Lock()
+	holder.instance = instance
+	holder.mu.Unlock()
+}
+
+// Instance returns the instance of ctx.
+// If ctx was created by [NewInstanceContext], the instance is only queried once
+// unless shouldTriggerBulk is set or ctx carries a [ConsistencyToken] of [Queries.WithConsistency].
+func (q *Queries) Instance(ctx context.Context, shouldTriggerBulk bool) (instance *Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instanceID := authz.GetInstance(ctx).InstanceID()
+	_, awaitConsistency := consistencyTokenFromContext(ctx)
+	if cached, ok := InstanceFromContext(ctx); ok && !shouldTriggerBulk && !awaitConsistency && cached.ID == instanceID {
+		return cached, nil
+	}
+	if err = q.awaitConsistency(ctx); err != nil {
+		return nil, err
+	}
+
+	if shouldTriggerBulk {
+		_, traceSpan := tracing.NewNamedSpan(ctx, "TriggerInstanceProjection")
+		ctx, err = projection.InstanceProjection.Trigger(ctx, handler.WithAwaitRunning())
+		logging.OnError(err).Debug("trigger failed")
+		traceSpan.EndWithError(err)
+	}
+
+	instance, err = q.instanceByID(ctx, instanceID)
+	if err == nil {
+		setInstanceContext(ctx, instance)
+	}
+	return instance, err
+}
+
+// InstanceProjectionLag returns the latest sequence of the instance aggregate in the eventstore
+// and the sequence of the instance in the instance projection.
+// The projection lags behind the eventstore as long as projectionSeq is lower than eventstoreSeq.
+func (q *Queries) InstanceProjectionLag(ctx context.Context, instanceID string) (eventstoreSeq, projectionSeq uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	latest, err := q.eventstore.LatestSequence(authz.WithInstanceID(ctx, instanceID),
+		eventstore.NewSearchQueryBuilder(eventstore.ColumnsMaxSequence).
+			AwaitOpenTransactions().
+			AddQuery().
+			AggregateTypes(instance_repo.AggregateType).
+			AggregateIDs(instanceID).
+			Builder(),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	projectionSeq, err = q.instanceProjectionSequence(ctx, instanceID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(latest), projectionSeq, nil
+}
+
+// instanceProjectionSequence returns the sequence of the instance in the instance projection.
+func (q *Queries) instanceProjectionSequence(ctx context.Context, instanceID string) (sequence uint64, err error) {
+	stmt, args, err := sq.Select(InstanceColumnSequence.identifier()).
+		From(instanceTable.identifier()).
+		Where(sq.Eq{InstanceColumnID.identifier(): instanceID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, zerrors.ThrowInternal(err, "QUERY-Wee1u", "Errors.Query.SQLStatement")
+	}
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&sequence)
+	}, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, zerrors.ThrowNotFound(err, "QUERY-Jae8o", "Errors.IAM.NotFound")
+	}
+	if err != nil {
+		return 0, zerrors.ThrowInternal(err, "QUERY-ukoo2", "Errors.Internal")
+	}
+	return sequence, nil
+}
+
+// InstanceEvent describes who created an instance and when.
+type InstanceEvent struct {
+	InstanceID   string
+	Creator      string
+	CreationDate time.Time
+	Sequence     uint64
+}
+
+// InstanceCreationEvent returns the instance.added event of the instance.
+// The eventstore does not record a correlation id, so only the creator and the creation date are returned.
+func (q *Queries) InstanceCreationEvent(ctx context.Context, instanceID string) (_ *InstanceEvent, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	events, err := q.eventstore.Filter(ctx,
+		eventstore.NewSearchQueryBuilder(eventstore.ColumnsEvent).
+			InstanceID(instanceID).
+			Limit(1).
+			AddQuery().
+			AggregateTypes(instance_repo.AggregateType).
+			AggregateIDs(instanceID).
+			EventTypes(instance_repo.InstanceAddedEventType).
+			Builder(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, zerrors.ThrowNotFound(nil, "QUERY-Vah4e", "Errors.IAM.NotFound")
+	}
+	return &InstanceEvent{
+		InstanceID:   events[0].Aggregate().ID,
+		Creator:      events[0].Creator(),
+		CreationDate: events[0].CreatedAt(),
+		Sequence:     events[0].Sequence(),
+	}, nil
+}
+
+// InstanceOnConn returns the instance of ctx like [Queries.Instance], but runs the query on conn.
+// It allows callers to keep the reads of an instance on the same database connection.
+// The instance is neither taken from nor stored on an instance context.
+func (q *Queries) InstanceOnConn(ctx context.Context, conn *sql.Conn) (instance *Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, scan := prepareInstanceDomainQuery(ctx, q.client)
+	query, args, err := stmt.Where(sq.Eq{
+		InstanceColumnID.identifier(): authz.GetInstance(ctx).InstanceID(),
+	}).ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Aeph8", "Errors.Query.SQLStatement")
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-eiB8u", "Errors.Internal")
+	}
+	defer func() {
+		closeErr := rows.Close()
+		logging.OnError(closeErr).Info("rows.Close failed")
+	}()
+	return scan(rows)
+}
+
+// instanceChangeDatePollInterval is the interval in which [Queries.InstanceAfterChangeDate] queries the instance.
+var instanceChangeDatePollInterval = 100 * time.Millisecond
+
+// InstanceAfterChangeDate returns the instance of ctx as soon as its change date is at least minChange.
+// Command handlers can pass the creation date of the pushed events to read their own writes.
+// The instance is queried until then or until ctx is done, so ctx should have a deadline.
+func (q *Queries) InstanceAfterChangeDate(ctx context.Context, minChange time.Time) (instance *Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instanceID := authz.GetInstance(ctx).InstanceID()
+	ticker := time.NewTicker(instanceChangeDatePollInterval)
+	defer ticker.Stop()
+	for {
+		instance, err = q.instanceByID(ctx, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		if !instance.ChangeDate.Before(minChange) {
+			setInstanceContext(ctx, instance)
+			return instance, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, zerrors.ThrowDeadlineExceeded(ctx.Err(), "QUERY-ieT3o", "Errors.Internal")
+		case <-ticker.C:
+		}
+	}
+}
+
+// ConsistencyToken holds the minimum sequence of instance aggregates, by instance id,
+// which must be projected before [Queries.SearchInstances] and [Queries.Instance] read.
+// Command handlers can return the sequences of the pushed events to read their own writes in later queries of a request.
+type ConsistencyToken struct {
+	Sequences map[string]uint64
+}
+
+type consistencyTokenKey struct{}
+
+// consistencyPollInterval is the interval in which the instance projection is queried until it reached a [ConsistencyToken].
+var consistencyPollInterval = 100 * time.Millisecond
+
+// WithConsistency returns a context in which [Queries.SearchInstances] and [Queries.Instance]
+// wait until the instance projection reached the sequences of token.
+// The projection is queried until then or until ctx is done, so ctx should have a deadline.
+func (q *Queries) WithConsistency(ctx context.Context, token ConsistencyToken) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+func consistencyTokenFromContext(ctx context.Context) (ConsistencyToken, bool) {
+	token, ok := ctx.Value(consistencyTokenKey{}).(ConsistencyToken)
+	return token, ok
+}
+
+// awaitConsistency blocks until the instance projection reached the [ConsistencyToken] of ctx, if any.
+// Instances which are not projected yet are awaited as well.
+func (q *Queries) awaitConsistency(ctx context.Context) error {
+	token, ok := consistencyTokenFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	ids := make([]string, 0, len(token.Sequences))
+	for id := range token.Sequences {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	ticker := time.NewTicker(consistencyPollInterval)
+	defer ticker.Stop()
+	for _, id := range ids {
+		for {
+			sequence, err := q.instanceProjectionSequence(ctx, id)
+			if err != nil && !zerrors.IsNotFound(err) {
+				return err
+			}
+			if sequence >= token.Sequences[id] {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return zerrors.ThrowDeadlineExceeded(ctx.Err(), "QUERY-Iel4a", "Errors.Internal")
+			case <-ticker.C:
+			}
+		}
+	}
+	return nil
+}
+
+func (q *Queries) instanceByID(ctx context.Context, instanceID string) (instance *Instance, err error) {
+	stmt, scan := prepareInstanceDomainQuery(ctx, q.client)
+	query, args, err := stmt.Where(sq.Eq{
+		InstanceColumnID.identifier(): instanceID,
+	}).ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-d9ngs", "Errors.Query.SQLStatement")
+	}
+
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		instance, err = scan(rows)
+		return err
+	}, query, args...)
+	return instance, err
+}
+
+var (
+	//go:embed instance_by_domain.sql
+	instanceByDomainQuery string
+
+	//go:embed instance_by_id.sql
+	instanceByIDQuery string
+)
+
+func (q *Queries) InstanceByHost(ctx context.Context, instanceHost, publicHost string) (_ authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("unable to get instance by host: instanceHost %s, publicHost %s: %w", instanceHost, publicHost, err)
+		}
+		span.EndWithError(err)
+	}()
+
+	instanceDomain := asciiHost(strings.Split(instanceHost, ":")[0]) // remove possible port
+	publicDomain := asciiHost(strings.Split(publicHost, ":")[0])     // remove possible port
+
+	instance, ok := q.caches.instance.Get(ctx, instanceIndexByHost, instanceDomain)
+	if ok {
+		q.refreshInstanceAhead(ctx, instance)
+		return instance, instance.checkDomain(instanceDomain, publicDomain)
+	}
+	instances, err := q.instancesByDomain(ctx, instanceDomain)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) > 1 {
+		return nil, zerrors.ThrowInternal(fmt.Errorf("%d instances claim host %s", len(instances), instanceDomain), "QUERY-eeW4o", "Errors.Internal")
+	}
+	instance = instances[0]
+	q.setInstanceCache(ctx, instance)
+
+	return instance, instance.checkDomain(instanceDomain, publicDomain)
+}
+
+// InstancesByHostStrict returns every instance claiming host, skipping the instance cache.
+// Unlike [Queries.InstanceByHost] it does not fail if a host is claimed by more than one instance,
+// which allows to detect and resolve such conflicts.
+func (q *Queries) InstancesByHostStrict(ctx context.Context, host string) (_ []authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instances, err := q.instancesByDomain(ctx, asciiHost(strings.Split(host, ":")[0])) // remove possible port
+	if err != nil {
+		return nil, err
+	}
+	result := make([]authz.Instance, len(instances))
+	for i, instance := range instances {
+		result[i] = instance
+	}
+	return result, nil
+}
+
+// instancesByDomain returns all instances with the instance domain.
+// If no instance matches, a not found error is returned.
+func (q *Queries) instancesByDomain(ctx context.Context, instanceDomain string) (instances []*authzInstance, err error) {
+	var scanErr error
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			instance := new(authzInstance)
+			if scanErr = instance.scan(rows.Scan); scanErr != nil {
+				return scanErr
+			}
+			instances = append(instances, instance)
+		}
+		return rows.Err()
+	}, instanceByDomainQuery, instanceDomain)
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Aiy7e", "Errors.Internal")
+	}
+	if len(instances) == 0 {
+		return nil, errAuthzInstanceNotFound()
+	}
+	return instances, nil
+}
+
+// InstanceByHostOrFallback returns the instance of host like [Queries.InstanceByHost].
+// If no instance is found for host, the instance with fallbackInstanceID is returned instead,
+// for example to serve a branded not found page. The returned bool reports if the fallback was used.
+// Without fallbackInstanceID, the not found error of the host is returned.
+func (q *Queries) InstanceByHostOrFallback(ctx context.Context, host, fallbackInstanceID string) (_ authz.Instance, fallback bool, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance, err := q.InstanceByHost(ctx, host, "")
+	if err == nil {
+		return instance, false, nil
+	}
+	notFoundErr := new(zerrors.NotFoundError)
+	if fallbackInstanceID == "" || !errors.As(err, &notFoundErr) {
+		return nil, false, err
+	}
+	instance, err = q.InstanceByID(ctx, fallbackInstanceID)
+	if err != nil {
+		return nil, false, err
+	}
+	return instance, true, nil
+}
+
+// asciiHost converts an internationalized host to its punycode form,
+// which is the only form instance domains are stored in.
+// ASCII hosts and hosts which cannot be converted are returned unchanged.
+func asciiHost(host string) string {
+	for _, r := range host {
+		if r >= utf8.RuneSelf {
+			ascii, err := idna.Lookup.ToASCII(host)
+			if err != nil {
+				return host
+			}
+			return ascii
+		}
+	}
+	return host
+}
+
+// InstanceBySNI returns the instance of the TLS server name indication, for example before the HTTP request is read.
+// The server name is lower cased, a trailing dot is removed
+// and internationalized domain names are converted to their ASCII (punycode) form.
+func (q *Queries) InstanceBySNI(ctx context.Context, serverName string) (_ authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	host, err := idna.Lookup.ToASCII(strings.TrimSuffix(serverName, "."))
+	if err != nil {
+		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-ooC3a", "Errors.Instance.Domain.InvalidCharacter")
+	}
+	return q.InstanceByHost(ctx, strings.ToLower(host), "")
+}
+
+// InstanceFromGRPCMetadata returns the instance of the host passed in the incoming gRPC metadata headerKey,
+// for example by a gateway which does not forward the original host as authority.
+// The host is trimmed and lower cased before it is resolved like [Queries.InstanceByHost].
+// If the header is absent or contains different hosts, an invalid argument error is returned.
+func (q *Queries) InstanceFromGRPCMetadata(ctx context.Context, headerKey string) (_ authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	var host string
+	for _, value := range metadata.ValueFromIncomingContext(ctx, headerKey) {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		if host != "" && host != value {
+			return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("header %s contains multiple hosts", headerKey), "QUERY-ooK2e", "Errors.Query.InvalidRequest")
+		}
+		host = value
+	}
+	if host == "" {
+		return nil, zerrors.ThrowInvalidArgument(fmt.Errorf("header %s is missing", headerKey), "QUERY-Ahx4i", "Errors.Query.InvalidRequest")
+	}
+	return q.InstanceByHost(ctx, host, "")
+}
+
+func (q *Queries) InstanceByID(ctx context.Context, id string) (_ authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	return q.authzInstanceByID(ctx, id)
+}
+
+// InstanceByAPIKeyPrefix returns the instance owning the authentication key of a machine user.
+// The prefix is the id of the key, which is the non-secret part of a key file and is unique across instances.
+// The key itself is not validated.
+func (q *Queries) InstanceByAPIKeyPrefix(ctx context.Context, prefix string) (_ authz.Instance, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Ooy3a", "Errors.IDMissing")
+	}
+	stmt, args, err := sq.Select(AuthNKeyColumnInstanceID.identifier()).
+		From(authNKeyTable.identifier()).
+		Where(sq.Eq{AuthNKeyColumnID.identifier(): prefix}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Thai8", "Errors.Query.SQLStatement")
+	}
+	var instanceID string
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&instanceID)
+	}, stmt, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, zerrors.ThrowNotFound(err, "QUERY-Eez2u", "Errors.AuthNKey.NotFound")
+	}
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ue0ch", "Errors.Internal")
+	}
+	return q.authzInstanceByID(ctx, instanceID)
+}
+
+// InstanceTrustedDomains returns the domains and trusted domains of the instance of the context,
+// lower cased, deduplicated and sorted, for example to build CSP or CORS headers.
+func (q *Queries) InstanceTrustedDomains(ctx context.Context) (domains []string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance, err := q.authzInstanceByID(ctx, authz.GetInstance(ctx).InstanceID())
+	if err != nil {
+		return nil, err
+	}
+	domains = make([]string, 0, len(instance.ExternalDomains)+len(instance.TrustedDomains))
+	for _, domain := range slices.Concat(instance.ExternalDomains, instance.TrustedDomains) {
+		domains = append(domains, strings.ToLower(domain))
+	}
+	slices.Sort(domains)
+	return slices.Compact(domains), nil
+}
+
+func (q *Queries) authzInstanceByID(ctx context.Context, id string) (*authzInstance, error) {
+	instance, ok := q.caches.instance.Get(ctx, instanceIndexByID, id)
+	if ok {
+		q.refreshInstanceAhead(ctx, instance)
+		return instance, nil
+	}
+
+	instance, scan := scanAuthzInstance()
+	err := q.client.QueryRowContext(ctx, scan, instanceByIDQuery, id)
+	logging.OnError(err).WithField("instance_id", id).Warn("instance by ID")
+	if err == nil {
+		q.setInstanceCache(ctx, instance)
+	}
+	return instance, err
+}
+
+// WithInstanceContext returns a context with the instance of instanceID set as [authz.Instance],
+// for example for background jobs which are not started by a request to an instance host.
+// Subsequent calls like [Queries.Instance] then resolve this instance.
+func (q *Queries) WithInstanceContext(ctx context.Context, instanceID string) (_ context.Context, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance, err := q.InstanceByID(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return authz.WithInstance(ctx, instance), nil
+}
+
+// WithInstanceCacheRefreshAhead enables the asynchronous refresh of cached instances
+// which are read within window before they reach the max age of the cache.
+// The refresh threshold is jittered on every read,
+// so entries cached at the same time are not refreshed simultaneously.
+// It has no effect if the instance cache has no max age configured.
+func WithInstanceCacheRefreshAhead(window time.Duration) QueryOption {
+	return func(q *Queries) {
+		q.instanceCacheRefreshAhead = window
+	}
+}
+
+func (q *Queries) setInstanceCache(ctx context.Context, instance *authzInstance) {
+	instance.CachedAt = time.Now()
+	q.caches.instance.Set(ctx, instance)
+}
+
+// refreshInstanceAhead reloads the instance in the background and updates the cache
+// if the cached instance is due for refresh.
+// At most one refresh per instance is running at a time.
+func (q *Queries) refreshInstanceAhead(ctx context.Context, instance *authzInstance) {
+	if !q.instanceRefreshDue(instance) {
+		return
+	}
+	if _, running := q.caches.instanceRefreshing.LoadOrStore(instance.ID, struct{}{}); running {
+		return
+	}
+	go func(ctx context.Context, id string) {
+		defer q.caches.instanceRefreshing.Delete(id)
+
+		refreshed, scan := scanAuthzInstance()
+		if err := q.client.QueryRowContext(ctx, scan, instanceByIDQuery, id); err != nil {
+			logging.WithFields("instance_id", id).OnError(err).Warn("instance cache refresh failed")
+			return
+		}
+		q.setInstanceCache(ctx, refreshed)
+	}(context.WithoutCancel(ctx), instance.ID)
+}
+
+func (q *Queries) instanceRefreshDue(instance *authzInstance) bool {
+	window, maxAge := q.instanceCacheRefreshAhead, q.caches.instanceMaxAge
+	if window <= 0 || maxAge <= 0 || instance.CachedAt.IsZero() {
+		return false
+	}
+	window = min(window, maxAge)
+	threshold := maxAge - time.Duration(rand.Int64N(int64(window))+1)
+	return time.Since(instance.CachedAt) >= threshold
+}
+
+// ExistingInstanceIDs returns the ids of the existing instances, in the order they are passed.
+func (q *Queries) ExistingInstanceIDs(ctx context.Context, ids ...string) (existing []string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	if len(ids) == 0 {
+		return []string{}, nil
+	}
+	stmt, args, err := sq.Select(InstanceColumnID.identifier()).
+		From(instanceTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.Eq{InstanceColumnID.identifier(): ids}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Quo5e", "Errors.Query.SQLStatement")
+	}
+
+	found := make(map[string]bool, len(ids))
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			found[id] = true
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ohs4a", "Errors.Internal")
+	}
+
+	existing = make([]string, 0, len(found))
+	for _, id := range ids {
+		if found[id] {
+			existing = append(existing, id)
+			// only return duplicate ids once
+			delete(found, id)
+		}
+	}
+	return existing, nil
+}
+
+// InstancesWithoutDomains returns the instances which have no domain at all.
+// Such instances cannot be resolved by [Queries.InstanceByHost], for example after a failed setup.
+// The returned instances have no Domains.
+func (q *Queries) InstancesWithoutDomains(ctx context.Context) (instances *Instances, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, args, err := sq.Select(
+		InstanceColumnID.identifier(),
+		InstanceColumnCreationDate.identifier(),
+		InstanceColumnChangeDate.identifier(),
+		InstanceColumnSequence.identifier(),
+		InstanceColumnName.identifier(),
+		InstanceColumnDefaultOrgID.identifier(),
+		InstanceColumnProjectID.identifier(),
+		InstanceColumnConsoleID.identifier(),
+		InstanceColumnConsoleAppID.identifier(),
+		InstanceColumnDefaultLanguage.identifier(),
+	).
+		From(instanceTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(
+			"NOT EXISTS (SELECT 1 FROM " + instanceDomainsTable.identifier() +
+				" WHERE " + InstanceDomainInstanceIDCol.identifier() + " = " + InstanceColumnID.identifier() + ")",
+		).
+		OrderBy(InstanceColumnID.identifier()).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-oth7E", "Errors.Query.SQLStatement")
+	}
+
+	instances = &Instances{Instances: []*Instance{}}
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			instance := &Instance{Domains: []*InstanceDomain{}}
+			var lang string
+			if err := rows.Scan(
+				&instance.ID,
+				&instance.CreationDate,
+				&instance.ChangeDate,
+				&instance.Sequence,
+				&instance.Name,
+				&instance.DefaultOrgID,
+				&instance.IAMProjectID,
+				&instance.ConsoleID,
+				&instance.ConsoleAppID,
+				&lang,
+			); err != nil {
+				return err
+			}
+			instance.DefaultLang = language.Make(lang)
+			instances.Instances = append(instances.Instances, instance)
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Xee2i", "Errors.Internal")
+	}
+	instances.Count = uint64(len(instances.Instances))
+	return instances, nil
+}
+
+// InstanceConsoleRedirectURIs returns the redirect URIs of the console app of the instance in ctx.
+// An empty slice is returned if the instance has no console app configured.
+func (q *Queries) InstanceConsoleRedirectURIs(ctx context.Context) (uris []string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance := authz.GetInstance(ctx)
+	if instance.ConsoleApplicationID() == "" {
+		return []string{}, nil
+	}
+	query, args, err := sq.Select(AppOIDCConfigColumnRedirectUris.identifier()).
+		From(appOIDCConfigsTable.identifier()).
+		Where(sq.Eq{
+			AppOIDCConfigColumnAppID.identifier():      instance.ConsoleApplicationID(),
+			appOIDCConfigsTable.InstanceIDIdentifier(): instance.InstanceID(),
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-ohZ4e", "Errors.Query.SQLStatement")
+	}
+
+	var redirectURIs database.TextArray[string]
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&redirectURIs)
+	}, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ieph7", "Errors.Internal")
+	}
+	if redirectURIs == nil {
+		return []string{}, nil
+	}
+	return redirectURIs, nil
+}
+
+// InstanceConsoleOIDCConfig returns the OIDC configuration of the console app of the ctx instance.
+// A not found error is returned if the instance has no console app.
+func (q *Queries) InstanceConsoleOIDCConfig(ctx context.Context) (config *OIDCApp, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	consoleAppID := authz.GetInstance(ctx).ConsoleApplicationID()
+	if consoleAppID == "" {
+		return nil, zerrors.ThrowNotFound(nil, "QUERY-Ro8ai", "Errors.App.NotExisting")
+	}
+	app, err := q.AppByID(ctx, consoleAppID, false)
+	if err != nil {
+		return nil, err
+	}
+	if app.OIDCConfig == nil {
+		return nil, zerrors.ThrowNotFound(nil, "QUERY-Xoh3e", "Errors.App.NotExisting")
+	}
+	return app.OIDCConfig, nil
+}
+
+// InstanceFull is the instance of the context
+// together with its IAM project and console application.
+type InstanceFull struct {
+	*Instance
+	Project    *Project
+	ConsoleApp *App
+}
+
+// InstanceFull returns the instance of the context including its IAM project and console application.
+// The instance, project and application are read by separate queries, not from one snapshot.
+// An error is returned if the console application does not belong to the IAM project.
+func (q *Queries) InstanceFull(ctx context.Context) (full *InstanceFull, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance, err := q.instanceByID(ctx, authz.GetInstance(ctx).InstanceID())
+	if err != nil {
+		return nil, err
+	}
+	project, err := q.ProjectByID(ctx, false, instance.IAMProjectID)
+	if err != nil {
+		return nil, err
+	}
+	app, err := q.AppByID(ctx, instance.ConsoleAppID, false)
+	if err != nil {
+		return nil, err
+	}
+	if app.ProjectID != project.ID {
+		return nil, zerrors.ThrowInternal(nil, "QUERY-ooL6e", "Errors.Internal")
+	}
+	return &InstanceFull{
+		Instance:   instance,
+		Project:    project,
+		ConsoleApp: app,
+	}, nil
+}
+
+// InstanceWithQuotas returns the instance of the context and its quotas.
+// The quotas are nil if none are configured, which means the instance is unlimited.
+func (q *Queries) InstanceWithQuotas(ctx context.Context) (_ *Instance, _ *InstanceQuotas, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	instance, err := q.instanceByID(ctx, authz.GetInstance(ctx).InstanceID())
+	if err != nil {
+		return nil, nil, err
+	}
+	quotas, err := q.instanceQuotas(ctx, instance.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return instance, quotas, nil
+}
+
+// BucketSize defines the time span aggregated by a bucket of a histogram.
+type BucketSize int
+
+const (
+	BucketSizeDay BucketSize = iota
+	BucketSizeWeek
+	BucketSizeMonth
+)
+
+// truncField returns the field passed to date_trunc.
+func (b BucketSize) truncField() (string, bool) {
+	switch b {
+	case BucketSizeDay:
+		return "day", true
+	case BucketSizeWeek:
+		return "week", true
+	case BucketSizeMonth:
+		return "month", true
+	default:
+		return "", false
+	}
+}
+
+// InstanceCreationHistogram counts the created instances per bucket.
+// The buckets are keyed by their start in UTC and only contain buckets with at least one instance.
+// Weeks start on monday.
+func (q *Queries) InstanceCreationHistogram(ctx context.Context, bucket BucketSize) (histogram map[time.Time]uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	field, ok := bucket.truncField()
+	if !ok {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Ood5i", "Errors.Query.InvalidRequest")
+	}
+	stmt, args, err := sq.Select(
+		"date_trunc('"+field+"', "+InstanceColumnCreationDate.identifier()+" AT TIME ZONE 'UTC') AS bucket",
+		"COUNT(*)",
+	).
+		From(instanceTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		GroupBy("bucket").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-ahT4j", "Errors.Query.SQLStatement")
+	}
+
+	histogram = make(map[time.Time]uint64)
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				start time.Time
+				count uint64
+			)
+			if err := rows.Scan(&start, &count); err != nil {
+				return err
+			}
+			// the truncated timestamp has no time zone, make sure it is interpreted as UTC
+			start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+			histogram[start] += count
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ahd3x", "Errors.Internal")
+	}
+	return histogram, nil
 }
 
-type Instances struct {
-	SearchResponse
-	Instances []*Instance
+// InstanceUserCounts counts the users per instance, excluding deactivated and removed users.
+// If no instanceIDs are passed, all instances are counted.
+// Instances without users are included with a count of 0.
+func (q *Queries) InstanceUserCounts(ctx context.Context, instanceIDs ...string) (counts map[string]uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	return q.queryInstanceCounts(ctx, UserIDCol, countedUsers, instanceIDs)
 }
 
-type InstanceSearchQueries struct {
-	SearchRequest
-	Queries []SearchQuery
+// countedUsers excludes deactivated and removed users from the user counts of instances.
+var countedUsers = sq.NotEq{
+	UserStateCol.identifier(): []domain.UserState{domain.UserStateInactive, domain.UserStateDeleted},
 }
 
-func NewInstanceIDsListSearchQuery(ids ...string) (SearchQuery, error) {
-	list := make([]interface{}, len(ids))
-	for i, value := range ids {
-		list[i] = value
-	}
-	return NewListQuery(InstanceColumnID, list, ListIn)
+// InstanceSessionCounts returns the amount of active sessions per instance.
+// Terminated sessions are not counted.
+// If instanceIDs are passed, only the counts of these instances are returned, otherwise the counts of all instances.
+func (q *Queries) InstanceSessionCounts(ctx context.Context, instanceIDs ...string) (counts map[string]uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	return q.queryInstanceCounts(ctx, SessionColumnID, sq.Eq{SessionColumnState.identifier(): domain.SessionStateActive}, instanceIDs)
 }
 
-func NewInstanceDomainsListSearchQuery(domains ...string) (SearchQuery, error) {
-	list := make([]interface{}, len(domains))
-	for i, value := range domains {
-		list[i] = value
-	}
+// InstanceIDPCounts returns the amount of active identity providers per instance,
+// including the providers of the organizations of the instance.
+// Inactive providers and the providers of removed organizations are not counted, like in [NewInstanceHasIDPSearchQuery].
+// If instanceIDs are passed, only the counts of these instances are returned, otherwise the counts of all instances.
+func (q *Queries) InstanceIDPCounts(ctx context.Context, instanceIDs ...string) (counts map[string]uint64, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
 
-	return NewListQuery(InstanceDomainDomainCol, list, ListIn)
+	return q.queryInstanceCounts(ctx, IDPTemplateIDCol, sq.Eq{
+		IDPTemplateStateCol.identifier():        domain.IDPStateActive,
+		IDPTemplateOwnerRemovedCol.identifier(): false,
+	}, instanceIDs)
 }
 
-func (q *InstanceSearchQueries) toQuery(query sq.SelectBuilder) sq.SelectBuilder {
-	query = q.SearchRequest.toQuery(query)
-	for _, q := range q.Queries {
-		query = q.toQuery(query)
-	}
-	return query
+// InstanceUserCount is the amount of users of an instance.
+type InstanceUserCount struct {
+	InstanceID string
+	Name       string
+	Count      uint64
 }
 
-func (q *Queries) SearchInstances(ctx context.Context, queries *InstanceSearchQueries) (instances *Instances, err error) {
+// TopInstancesByUsers returns the limit instances with the most users, excluding deactivated and removed users.
+// Instances with the same amount of users are ordered by their id.
+// The limit is capped like the limit of [Queries.SearchInstances], a limit of 0 returns all instances up to the cap.
+func (q *Queries) TopInstancesByUsers(ctx context.Context, limit uint64) (counts []*InstanceUserCount, err error) {
 	ctx, span := tracing.NewSpan(ctx)
 	defer func() { span.EndWithError(err) }()
 
-	filter, query, scan := prepareInstancesQuery(ctx, q.client)
-	stmt, args, err := query(queries.toQuery(filter)).ToSql()
+	queries, err := q.limitInstanceResultSize(&InstanceSearchQueries{SearchRequest: SearchRequest{Limit: limit}})
 	if err != nil {
-		return nil, zerrors.ThrowInvalidArgument(err, "QUERY-M9fow", "Errors.Query.SQLStatement")
+		return nil, err
+	}
+	query, err := q.instanceCountsQuery(ctx, UserIDCol, countedUsers)
+	if err != nil {
+		return nil, err
+	}
+	query = query.
+		Columns(InstanceColumnName.identifier()).
+		GroupBy(InstanceColumnName.identifier()).
+		OrderBy("COUNT("+UserIDCol.identifier()+") DESC", InstanceColumnID.identifier())
+	if queries.Limit > 0 {
+		query = query.Limit(queries.Limit)
+	}
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Aiy9h", "Errors.Query.SQLStatement")
 	}
 
 	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
-		instances, err = scan(rows)
-		return err
+		for rows.Next() {
+			count := new(InstanceUserCount)
+			if err := rows.Scan(&count.InstanceID, &count.Count, &count.Name); err != nil {
+				return err
+			}
+			counts = append(counts, count)
+		}
+		return nil
 	}, stmt, args...)
 	if err != nil {
-		return nil, zerrors.ThrowInternal(err, "QUERY-3j98f", "Errors.Internal")
+		return nil, zerrors.ThrowInternal(err, "QUERY-ue4Ai", "Errors.Internal")
 	}
-	return instances, err
+	return counts, nil
 }
 
-func (q *Queries) Instance(ctx context.Context, shouldTriggerBulk bool) (instance *Instance, err error) {
+// queryInstanceCounts counts the rows of the table of countCol per instance, which match the condition.
+// The table is left joined on its instance id, so instances without matching rows are included with a count of 0.
+// If instanceIDs are passed, only those instances are counted.
+func (q *Queries) queryInstanceCounts(ctx context.Context, countCol Column, condition sq.Sqlizer, instanceIDs []string) (map[string]uint64, error) {
+	query, err := q.instanceCountsQuery(ctx, countCol, condition)
+	if err != nil {
+		return nil, err
+	}
+	if len(instanceIDs) > 0 {
+		query = query.Where(sq.Eq{InstanceColumnID.identifier(): instanceIDs})
+	}
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Thie5", "Errors.Query.SQLStatement")
+	}
+
+	counts := make(map[string]uint64, len(instanceIDs))
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				id    string
+				count uint64
+			)
+			if err := rows.Scan(&id, &count); err != nil {
+				return err
+			}
+			counts[id] = count
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-iePh1", "Errors.Internal")
+	}
+	return counts, nil
+}
+
+// instanceCountsQuery selects the instance id and the count of the rows of the table of countCol which match the condition,
+// grouped by the instance.
+func (q *Queries) instanceCountsQuery(ctx context.Context, countCol Column, condition sq.Sqlizer) (sq.SelectBuilder, error) {
+	on, onArgs, err := sq.And{
+		sq.Expr(countCol.table.InstanceIDIdentifier() + " = " + InstanceColumnID.identifier()),
+		condition,
+	}.ToSql()
+	if err != nil {
+		return sq.SelectBuilder{}, zerrors.ThrowInternal(err, "QUERY-ooN6a", "Errors.Query.SQLStatement")
+	}
+	return sq.Select(
+		InstanceColumnID.identifier(),
+		"COUNT("+countCol.identifier()+")",
+	).
+		From(instanceTable.identifier()).
+		LeftJoin(countCol.table.identifier()+" ON "+on+q.client.Timetravel(call.Took(ctx)), onArgs...).
+		GroupBy(InstanceColumnID.identifier()).
+		PlaceholderFormat(sq.Dollar), nil
+}
+
+func (q *Queries) GetDefaultLanguage(ctx context.Context) language.Tag {
+	instance, err := q.Instance(ctx, false)
+	if err != nil {
+		return language.Und
+	}
+	return instance.DefaultLang
+}
+
+// DefaultLanguages returns the default language of each instance with one of the instanceIDs,
+// or of all instances if no instanceIDs are passed.
+// Instances without a default language are mapped to [language.Und].
+func (q *Queries) DefaultLanguages(ctx context.Context, instanceIDs ...string) (languages map[string]language.Tag, err error) {
 	ctx, span := tracing.NewSpan(ctx)
 	defer func() { span.EndWithError(err) }()
 
-	if shouldTriggerBulk {
-		_, traceSpan := tracing.NewNamedSpan(ctx, "TriggerInstanceProjection")
-		ctx, err = projection.InstanceProjection.Trigger(ctx, handler.WithAwaitRunning())
-		logging.OnError(err).Debug("trigger failed")
-		traceSpan.EndWithError(err)
+	query := sq.Select(
+		InstanceColumnID.identifier(),
+		InstanceColumnDefaultLanguage.identifier(),
+	).
+		From(instanceTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		PlaceholderFormat(sq.Dollar)
+	if len(instanceIDs) > 0 {
+		query = query.Where(sq.Eq{InstanceColumnID.identifier(): instanceIDs})
 	}
-
-	stmt, scan := prepareInstanceDomainQuery(ctx, q.client)
-	query, args, err := stmt.Where(sq.Eq{
-		InstanceColumnID.identifier(): authz.GetInstance(ctx).InstanceID(),
-	}).ToSql()
+	stmt, args, err := query.ToSql()
 	if err != nil {
-		return nil, zerrors.ThrowInternal(err, "QUERY-d9ngs", "Errors.Query.SQLStatement")
+		return nil, zerrors.ThrowInternal(err, "QUERY-eaV3o", "Errors.Query.SQLStatement")
 	}
 
+	languages = make(map[string]language.Tag, len(instanceIDs))
 	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
-		instance, err = scan(rows)
-		return err
-	}, query, args...)
-	return instance, err
+		for rows.Next() {
+			var (
+				id   string
+				lang sql.NullString
+			)
+			if err := rows.Scan(&id, &lang); err != nil {
+				return err
+			}
+			languages[id] = language.Make(lang.String)
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Thoo7", "Errors.Internal")
+	}
+	return languages, nil
 }
 
-var (
-	//go:embed instance_by_domain.sql
-	instanceByDomainQuery string
-
-	//go:embed instance_by_id.sql
-	instanceByIDQuery string
+// The estimated average sizes of a row including its indexes, used by [Queries.InstanceStorageEstimate].
+const (
+	estimatedEventBytes   = 1024
+	estimatedUserBytes    = 2048
+	estimatedSessionBytes = 1024
 )
 
-func (q *Queries) InstanceByHost(ctx context.Context, instanceHost, publicHost string) (_ authz.Instance, err error) {
-	ctx, span := tracing.NewSpan(ctx)
-	defer func() {
-		if err != nil {
-			err = fmt.Errorf("unable to get instance by host: instanceHost %s, publicHost %s: %w", instanceHost, publicHost, err)
-		}
-		span.EndWithError(err)
-	}()
+// StorageEstimate is a rough estimate of the storage used by an instance.
+type StorageEstimate struct {
+	// Events is estimated from the sequences of the instance, its organizations, users and sessions in the projections,
+	// as the sequence of an aggregate is the amount of its events.
+	// Events of other aggregates, for example projects, are not included.
+	Events   uint64
+	Users    uint64
+	Sessions uint64
+	// Bytes is the sum of the rows multiplied by the estimated average size of their type.
+	// It is an estimate for capacity planning, the actual storage depends on the content and the database.
+	Bytes uint64
+}
 
-	instanceDomain := strings.Split(instanceHost, ":")[0] // remove possible port
-	publicDomain := strings.Split(publicHost, ":")[0]     // remove possible port
+// InstanceStorageEstimate counts the users and sessions of the instance, estimates its events and the storage they use.
+// Only projections are read, the eventstore is not queried.
+func (q *Queries) InstanceStorageEstimate(ctx context.Context, instanceID string) (estimate *StorageEstimate, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
 
-	instance, ok := q.caches.instance.Get(ctx, instanceIndexByHost, instanceDomain)
-	if ok {
-		return instance, instance.checkDomain(instanceDomain, publicDomain)
+	if instanceID == "" {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Eic8u", "Errors.IDMissing")
 	}
-	instance, scan := scanAuthzInstance()
-	if err = q.client.QueryRowContext(ctx, scan, instanceByDomainQuery, instanceDomain); err != nil {
-		return nil, err
+	aggregate := func(expr string, t table, alias string) sq.Sqlizer {
+		return sq.Alias(sq.Select(expr).From(t.identifier()).Where(sq.Eq{t.InstanceIDIdentifier(): instanceID}), alias)
+	}
+	sequences := func(col Column, alias string) sq.Sqlizer {
+		return aggregate("COALESCE(SUM("+col.identifier()+"), 0)", col.table, alias)
+	}
+	stmt, args, err := sq.Select().
+		Column(aggregate("COUNT(*)", userTable, "users")).
+		Column(aggregate("COUNT(*)", sessionsTable, "sessions")).
+		Column(sequences(InstanceColumnSequence, "instance_events")).
+		Column(sequences(OrgColumnSequence, "org_events")).
+		Column(sequences(UserSequenceCol, "user_events")).
+		Column(sequences(SessionColumnSequence, "session_events")).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Shoo3", "Errors.Query.SQLStatement")
 	}
-	q.caches.instance.Set(ctx, instance)
 
-	return instance, instance.checkDomain(instanceDomain, publicDomain)
+	var instanceEvents, orgEvents, userEvents, sessionEvents uint64
+	estimate = new(StorageEstimate)
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&estimate.Users, &estimate.Sessions, &instanceEvents, &orgEvents, &userEvents, &sessionEvents)
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ree7a", "Errors.Internal")
+	}
+	estimate.Events = instanceEvents + orgEvents + userEvents + sessionEvents
+	estimate.Bytes = estimate.Events*estimatedEventBytes +
+		estimate.Users*estimatedUserBytes +
+		estimate.Sessions*estimatedSessionBytes
+	return estimate, nil
 }
 
-func (q *Queries) InstanceByID(ctx context.Context, id string) (_ authz.Instance, err error) {
+// LanguageReportEntry summarizes the instances with the same default language.
+type LanguageReportEntry struct {
+	Count uint64
+	// SampleNames are instance names in alphabetical order, at most the requested amount.
+	SampleNames []string
+}
+
+// InstanceLanguageReport counts the instances per default language and samples up to samplesPerLang of their names.
+// Instances without a default language are reported for [language.Und].
+func (q *Queries) InstanceLanguageReport(ctx context.Context, samplesPerLang int) (report map[language.Tag]LanguageReportEntry, err error) {
 	ctx, span := tracing.NewSpan(ctx)
 	defer func() { span.EndWithError(err) }()
 
-	instance, ok := q.caches.instance.Get(ctx, instanceIndexByID, id)
-	if ok {
-		return instance, nil
+	if samplesPerLang < 0 {
+		return nil, zerrors.ThrowInvalidArgument(nil, "QUERY-Taj7o", "Errors.Query.InvalidRequest")
+	}
+	const alias = "l"
+	filter := sq.Select(
+		InstanceColumnDefaultLanguage.identifier(),
+		InstanceColumnName.identifier(),
+		"COUNT(*) OVER (PARTITION BY "+InstanceColumnDefaultLanguage.identifier()+") AS count",
+		"ROW_NUMBER() OVER (PARTITION BY "+InstanceColumnDefaultLanguage.identifier()+" ORDER BY "+InstanceColumnName.identifier()+", "+InstanceColumnID.identifier()+") AS sample",
+	).
+		From(instanceTable.identifier())
+	// every language needs at least one row to be reported, even without samples
+	stmt, args, err := sq.Select(
+		alias+"."+projection.InstanceColumnDefaultLanguage,
+		alias+"."+projection.InstanceColumnName,
+		alias+".count",
+	).
+		FromSelect(filter, alias).
+		Where(alias+".sample <= ?", max(samplesPerLang, 1)).
+		OrderBy(alias+"."+projection.InstanceColumnDefaultLanguage, alias+".sample").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Eong4", "Errors.Query.SQLStatement")
 	}
 
-	instance, scan := scanAuthzInstance()
-	err = q.client.QueryRowContext(ctx, scan, instanceByIDQuery, id)
-	logging.OnError(err).WithField("instance_id", id).Warn("instance by ID")
-	if err == nil {
-		q.caches.instance.Set(ctx, instance)
+	report = make(map[language.Tag]LanguageReportEntry)
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				lang  sql.NullString
+				name  string
+				count uint64
+			)
+			if err := rows.Scan(&lang, &name, &count); err != nil {
+				return err
+			}
+			tag := language.Make(lang.String)
+			entry := report[tag]
+			entry.Count = count
+			if len(entry.SampleNames) < samplesPerLang {
+				entry.SampleNames = append(entry.SampleNames, name)
+			}
+			report[tag] = entry
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Uu6ai", "Errors.Internal")
 	}
-	return instance, err
+	return report, nil
 }
 
-func (q *Queries) GetDefaultLanguage(ctx context.Context) language.Tag {
-	instance, err := q.Instance(ctx, false)
+// InstanceNameTaken reports if an instance with name exists, ignoring the case of the names.
+// It allows to reject a new instance "Acme" if the instance "acme" already exists.
+// The name is normalized with the normalizer set by [WithInstanceNameNormalizer] first.
+func (q *Queries) InstanceNameTaken(ctx context.Context, name string) (taken bool, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	name = q.normalizeInstanceName(name)
+	if name == "" {
+		return false, zerrors.ThrowInvalidArgument(nil, "QUERY-Oo5ah", "Errors.Query.InvalidRequest")
+	}
+	stmt, args, err := sq.Select("1").
+		Prefix("SELECT EXISTS (").
+		From(instanceTable.identifier()+q.client.Timetravel(call.Took(ctx))).
+		Where("LOWER("+InstanceColumnName.identifier()+") = LOWER(?)", name).
+		Suffix(")").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
 	if err != nil {
-		return language.Und
+		return false, zerrors.ThrowInternal(err, "QUERY-aeL7u", "Errors.Query.SQLStatement")
 	}
-	return instance.DefaultLang
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&taken)
+	}, stmt, args...)
+	if err != nil {
+		return false, zerrors.ThrowInternal(err, "QUERY-Jei6o", "Errors.Internal")
+	}
+	return taken, nil
 }
 
 func prepareInstancesQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilder, func(sq.SelectBuilder) sq.SelectBuilder, func(*sql.Rows) (*Instances, error)) {
+	return prepareInstancesQueryWithOrgName(ctx, db, false)
+}
+
+func prepareInstancesWithOrgNameQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilder, func(sq.SelectBuilder) sq.SelectBuilder, func(*sql.Rows) (*Instances, error)) {
+	return prepareInstancesQueryWithOrgName(ctx, db, true)
+}
+
+func prepareInstancesQueryWithOrgName(ctx context.Context, db prepareDatabase, withOrgName bool) (sq.SelectBuilder, func(sq.SelectBuilder) sq.SelectBuilder, func(*sql.Rows) (*Instances, error)) {
 	instanceFilterTable := instanceTable.setAlias(InstancesFilterTableAlias)
 	instanceFilterIDColumn := InstanceColumnID.setTable(instanceFilterTable)
 	instanceFilterCountColumn := InstancesFilterTableAlias + ".count"
@@ -258,7 +2596,7 @@ func prepareInstancesQuery(ctx context.Context, db prepareDatabase) (sq.SelectBu
 		).Distinct().From(instanceTable.identifier()).
 			LeftJoin(join(InstanceDomainInstanceIDCol, InstanceColumnID)),
 		func(builder sq.SelectBuilder) sq.SelectBuilder {
-			return sq.Select(
+			columns := []string{
 				instanceFilterCountColumn,
 				instanceFilterIDColumn.identifier(),
 				InstanceColumnCreationDate.identifier(),
@@ -270,74 +2608,35 @@ func prepareInstancesQuery(ctx context.Context, db prepareDatabase) (sq.SelectBu
 				InstanceColumnConsoleID.identifier(),
 				InstanceColumnConsoleAppID.identifier(),
 				InstanceColumnDefaultLanguage.identifier(),
+				LimitsColumnAuditLogRetention.identifier(),
 				InstanceDomainDomainCol.identifier(),
 				InstanceDomainIsPrimaryCol.identifier(),
 				InstanceDomainIsGeneratedCol.identifier(),
 				InstanceDomainCreationDateCol.identifier(),
 				InstanceDomainChangeDateCol.identifier(),
 				InstanceDomainSequenceCol.identifier(),
-			).FromSelect(builder, InstancesFilterTableAlias).
+			}
+			if withOrgName {
+				columns = append(columns, OrgColumnName.identifier())
+			}
+			query := sq.Select(columns...).FromSelect(builder, InstancesFilterTableAlias).
 				LeftJoin(join(InstanceColumnID, instanceFilterIDColumn)).
+				LeftJoin(join(LimitsColumnInstanceID, instanceFilterIDColumn))
+			if withOrgName {
+				query = query.LeftJoin(join(OrgColumnID, InstanceColumnDefaultOrgID))
+			}
+			return query.
 				LeftJoin(join(InstanceDomainInstanceIDCol, instanceFilterIDColumn) + db.Timetravel(call.Took(ctx))).
 				PlaceholderFormat(sq.Dollar)
 		},
 		func(rows *sql.Rows) (*Instances, error) {
 			instances := make([]*Instance, 0)
-			var lastInstance *Instance
-			var count uint64
-			for rows.Next() {
-				instance := new(Instance)
-				lang := ""
-				var (
-					domain       sql.NullString
-					isPrimary    sql.NullBool
-					isGenerated  sql.NullBool
-					changeDate   sql.NullTime
-					creationDate sql.NullTime
-					sequence     sql.NullInt64
-				)
-				err := rows.Scan(
-					&count,
-					&instance.ID,
-					&instance.CreationDate,
-					&instance.ChangeDate,
-					&instance.Sequence,
-					&instance.Name,
-					&instance.DefaultOrgID,
-					&instance.IAMProjectID,
-					&instance.ConsoleID,
-					&instance.ConsoleAppID,
-					&lang,
-					&domain,
-					&isPrimary,
-					&isGenerated,
-					&changeDate,
-					&creationDate,
-					&sequence,
-				)
-				if err != nil {
-					return nil, err
-				}
-				if instance.ID == "" || !domain.Valid {
-					continue
-				}
-				instance.DefaultLang = language.Make(lang)
-				instanceDomain := &InstanceDomain{
-					CreationDate: creationDate.Time,
-					ChangeDate:   changeDate.Time,
-					Sequence:     uint64(sequence.Int64),
-					Domain:       domain.String,
-					IsPrimary:    isPrimary.Bool,
-					IsGenerated:  isGenerated.Bool,
-					InstanceID:   instance.ID,
-				}
-				if lastInstance != nil && instance.ID == lastInstance.ID {
-					lastInstance.Domains = append(lastInstance.Domains, instanceDomain)
-					continue
-				}
-				lastInstance = instance
-				instance.Domains = append(instance.Domains, instanceDomain)
+			count, err := scanInstanceRows(rows, withOrgName, allocateInstance, func(instance *Instance) error {
 				instances = append(instances, instance)
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
 
 			if err := rows.Close(); err != nil {
@@ -353,6 +2652,91 @@ func prepareInstancesQuery(ctx context.Context, db prepareDatabase) (sq.SelectBu
 		}
 }
 
+// scanInstanceRows scans the rows of the query returned by [prepareInstancesQuery]
+// and calls fn for each instance, as soon as all of its domains are scanned.
+// The rows of an instance are expected to be consecutive.
+// withOrgName must be set if the query selects the name of the default organization.
+// newInstance returns the struct the next instance is scanned into.
+func scanInstanceRows(rows *sql.Rows, withOrgName bool, newInstance func() *Instance, fn func(*Instance) error) (count uint64, err error) {
+	var current *Instance
+	for rows.Next() {
+		var (
+			row               Instance
+			lang              string
+			auditLogRetention database.NullDuration
+			domain            sql.NullString
+			isPrimary         sql.NullBool
+			isGenerated       sql.NullBool
+			changeDate        sql.NullTime
+			creationDate      sql.NullTime
+			sequence          sql.NullInt64
+			orgName           sql.NullString
+		)
+		dest := []any{
+			&count,
+			&row.ID,
+			&row.CreationDate,
+			&row.ChangeDate,
+			&row.Sequence,
+			&row.Name,
+			&row.DefaultOrgID,
+			&row.IAMProjectID,
+			&row.ConsoleID,
+			&row.ConsoleAppID,
+			&lang,
+			&auditLogRetention,
+			&domain,
+			&isPrimary,
+			&isGenerated,
+			&changeDate,
+			&creationDate,
+			&sequence,
+		}
+		if withOrgName {
+			dest = append(dest, &orgName)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		if row.ID == "" || !domain.Valid {
+			continue
+		}
+		instanceDomain := &InstanceDomain{
+			CreationDate: creationDate.Time,
+			ChangeDate:   changeDate.Time,
+			Sequence:     uint64(sequence.Int64),
+			Domain:       domain.String,
+			IsPrimary:    isPrimary.Bool,
+			IsGenerated:  isGenerated.Bool,
+			InstanceID:   row.ID,
+		}
+		if current != nil && row.ID == current.ID {
+			current.Domains = append(current.Domains, instanceDomain)
+			continue
+		}
+		if current != nil {
+			if err = fn(current); err != nil {
+				return 0, err
+			}
+		}
+		current = newInstance()
+		domains := current.Domains[:0]
+		*current = row
+		current.DefaultLang = language.Make(lang)
+		if auditLogRetention.Valid {
+			current.AuditLogRetention = &auditLogRetention.Duration
+		}
+		current.DefaultOrgName = orgName.String
+		current.Domains = append(domains, instanceDomain)
+	}
+	if current != nil {
+		if err = fn(current); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
 func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilder, func(*sql.Rows) (*Instance, error)) {
 	return sq.Select(
 			InstanceColumnID.identifier(),
@@ -365,6 +2749,7 @@ func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.Sel
 			InstanceColumnConsoleID.identifier(),
 			InstanceColumnConsoleAppID.identifier(),
 			InstanceColumnDefaultLanguage.identifier(),
+			LimitsColumnAuditLogRetention.identifier(),
 			InstanceDomainDomainCol.identifier(),
 			InstanceDomainIsPrimaryCol.identifier(),
 			InstanceDomainIsGeneratedCol.identifier(),
@@ -373,6 +2758,7 @@ func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.Sel
 			InstanceDomainSequenceCol.identifier(),
 		).
 			From(instanceTable.identifier()).
+			LeftJoin(join(LimitsColumnInstanceID, InstanceColumnID)).
 			LeftJoin(join(InstanceDomainInstanceIDCol, InstanceColumnID) + db.Timetravel(call.Took(ctx))).
 			PlaceholderFormat(sq.Dollar),
 		func(rows *sql.Rows) (*Instance, error) {
@@ -382,12 +2768,13 @@ func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.Sel
 			lang := ""
 			for rows.Next() {
 				var (
-					domain       sql.NullString
-					isPrimary    sql.NullBool
-					isGenerated  sql.NullBool
-					changeDate   sql.NullTime
-					creationDate sql.NullTime
-					sequence     sql.NullInt64
+					auditLogRetention database.NullDuration
+					domain            sql.NullString
+					isPrimary         sql.NullBool
+					isGenerated       sql.NullBool
+					changeDate        sql.NullTime
+					creationDate      sql.NullTime
+					sequence          sql.NullInt64
 				)
 				err := rows.Scan(
 					&instance.ID,
@@ -400,6 +2787,7 @@ func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.Sel
 					&instance.ConsoleID,
 					&instance.ConsoleAppID,
 					&lang,
+					&auditLogRetention,
 					&domain,
 					&isPrimary,
 					&isGenerated,
@@ -410,6 +2798,9 @@ func prepareInstanceDomainQuery(ctx context.Context, db prepareDatabase) (sq.Sel
 				if err != nil {
 					return nil, zerrors.ThrowInternal(err, "QUERY-d9nw", "Errors.Internal")
 				}
+				if auditLogRetention.Valid {
+					instance.AuditLogRetention = &auditLogRetention.Duration
+				}
 				if !domain.Valid {
 					continue
 				}
@@ -448,6 +2839,7 @@ type authzInstance struct {
 	Feature         feature.Features           `json:"feature,omitempty"`
 	ExternalDomains database.TextArray[string] `json:"external_domains,omitempty"`
 	TrustedDomains  database.TextArray[string] `json:"trusted_domains,omitempty"`
+	CachedAt        time.Time                  `json:"cached_at,omitempty"`
 }
 
 type csp struct {
@@ -530,53 +2922,64 @@ func (i *authzInstance) Keys(index instanceIndex) []string {
 func scanAuthzInstance() (*authzInstance, func(row *sql.Row) error) {
 	instance := &authzInstance{}
 	return instance, func(row *sql.Row) error {
-		var (
-			lang                  string
-			enableIframeEmbedding sql.NullBool
-			enableImpersonation   sql.NullBool
-			auditLogRetention     database.NullDuration
-			block                 sql.NullBool
-			features              []byte
-		)
-		err := row.Scan(
-			&instance.ID,
-			&instance.DefaultOrgID,
-			&instance.IAMProjectID,
-			&instance.ConsoleID,
-			&instance.ConsoleAppID,
-			&lang,
-			&enableIframeEmbedding,
-			&instance.CSP.AllowedOrigins,
-			&enableImpersonation,
-			&auditLogRetention,
-			&block,
-			&features,
-			&instance.ExternalDomains,
-			&instance.TrustedDomains,
-		)
-		if errors.Is(err, sql.ErrNoRows) {
-			return zerrors.ThrowNotFound(nil, "QUERY-1kIjX", "Errors.IAM.NotFound")
-		}
-		if err != nil {
-			return zerrors.ThrowInternal(err, "QUERY-d3fas", "Errors.Internal")
-		}
-		instance.DefaultLang = language.Make(lang)
-		if auditLogRetention.Valid {
-			instance.LogRetention = &auditLogRetention.Duration
-		}
-		if block.Valid {
-			instance.IsBlocked = &block.Bool
-		}
-		instance.CSP.EnableIframeEmbedding = enableIframeEmbedding.Bool
-		instance.Impersonation = enableImpersonation.Bool
-		if len(features) == 0 {
-			return nil
-		}
-		if err = json.Unmarshal(features, &instance.Feature); err != nil {
-			return zerrors.ThrowInternal(err, "QUERY-Po8ki", "Errors.Internal")
-		}
+		return instance.scan(row.Scan)
+	}
+}
+
+// errAuthzInstanceNotFound is returned if no instance matches the id or domain of [instanceByIDQuery] or [instanceByDomainQuery].
+func errAuthzInstanceNotFound() error {
+	return zerrors.ThrowNotFound(nil, "QUERY-1kIjX", "Errors.IAM.NotFound")
+}
+
+// scan reads the columns of [instanceByDomainQuery] and [instanceByIDQuery] into instance using scan,
+// which is either [sql.Row.Scan] or [sql.Rows.Scan].
+func (instance *authzInstance) scan(scan func(dest ...any) error) error {
+	var (
+		lang                  string
+		enableIframeEmbedding sql.NullBool
+		enableImpersonation   sql.NullBool
+		auditLogRetention     database.NullDuration
+		block                 sql.NullBool
+		features              []byte
+	)
+	err := scan(
+		&instance.ID,
+		&instance.DefaultOrgID,
+		&instance.IAMProjectID,
+		&instance.ConsoleID,
+		&instance.ConsoleAppID,
+		&lang,
+		&enableIframeEmbedding,
+		&instance.CSP.AllowedOrigins,
+		&enableImpersonation,
+		&auditLogRetention,
+		&block,
+		&features,
+		&instance.ExternalDomains,
+		&instance.TrustedDomains,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errAuthzInstanceNotFound()
+	}
+	if err != nil {
+		return zerrors.ThrowInternal(err, "QUERY-d3fas", "Errors.Internal")
+	}
+	instance.DefaultLang = language.Make(lang)
+	if auditLogRetention.Valid {
+		instance.LogRetention = &auditLogRetention.Duration
+	}
+	if block.Valid {
+		instance.IsBlocked = &block.Bool
+	}
+	instance.CSP.EnableIframeEmbedding = enableIframeEmbedding.Bool
+	instance.Impersonation = enableImpersonation.Bool
+	if len(features) == 0 {
 		return nil
 	}
+	if err = json.Unmarshal(features, &instance.Feature); err != nil {
+		return zerrors.ThrowInternal(err, "QUERY-Po8ki", "Errors.Internal")
+	}
+	return nil
 }
 
 func (c *Caches) registerInstanceInvalidation() {
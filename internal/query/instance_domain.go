@@ -2,13 +2,20 @@ package query
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
 
 	"github.com/zitadel/zitadel/internal/api/authz"
 	"github.com/zitadel/zitadel/internal/api/call"
+	http_util "github.com/zitadel/zitadel/internal/api/http"
 	"github.com/zitadel/zitadel/internal/query/projection"
 	"github.com/zitadel/zitadel/internal/telemetry/tracing"
 	"github.com/zitadel/zitadel/internal/zerrors"
@@ -87,6 +94,181 @@ func (q *Queries) SearchInstanceDomainsGlobal(ctx context.Context, queries *Inst
 	return q.queryInstanceDomains(ctx, stmt, scan, args...)
 }
 
+// HostsForInstance returns all domains routing to the instance, lower cased and sorted.
+func (q *Queries) HostsForInstance(ctx context.Context, instanceID string) (hosts []string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, args, err := sq.Select(InstanceDomainDomainCol.identifier()).
+		From(instanceDomainsTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.Eq{InstanceDomainInstanceIDCol.identifier(): instanceID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Chie4", "Errors.Query.SQLStatement")
+	}
+
+	hosts = make([]string, 0)
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var host string
+			if err := rows.Scan(&host); err != nil {
+				return err
+			}
+			hosts = append(hosts, strings.ToLower(host))
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Ohb4u", "Errors.Internal")
+	}
+	slices.Sort(hosts)
+	return slices.Compact(hosts), nil
+}
+
+// InstanceRoutingFingerprint returns a hash of the domains of the instance and their primary flag.
+// It is independent of the order of the domains and changes if a domain is added, removed or its primary flag changes.
+func (q *Queries) InstanceRoutingFingerprint(ctx context.Context, instanceID string) (fingerprint string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, args, err := sq.Select(
+		InstanceDomainDomainCol.identifier(),
+		InstanceDomainIsPrimaryCol.identifier(),
+	).
+		From(instanceDomainsTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.Eq{InstanceDomainInstanceIDCol.identifier(): instanceID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return "", zerrors.ThrowInternal(err, "QUERY-Ua2ai", "Errors.Query.SQLStatement")
+	}
+
+	var routes []string
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				domain    string
+				isPrimary bool
+			)
+			if err := rows.Scan(&domain, &isPrimary); err != nil {
+				return err
+			}
+			routes = append(routes, strings.ToLower(domain)+"="+strconv.FormatBool(isPrimary))
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return "", zerrors.ThrowInternal(err, "QUERY-hoh6E", "Errors.Internal")
+	}
+	slices.Sort(routes)
+	hash := sha256.Sum256([]byte(strings.Join(routes, "\n")))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// IsPrimaryHost checks if host is the primary domain of the instance in ctx.
+// If not, the primary domain is returned, so the caller can redirect to it.
+// The returned primary domain is empty if the instance has none.
+// A host which does not belong to the instance results in a not found error.
+// The host is normalized like in [Queries.InstanceByHost]: the port is removed,
+// it is lower cased and internationalized domain names are converted to their ASCII (punycode) form.
+func (q *Queries) IsPrimaryHost(ctx context.Context, host string) (isPrimary bool, primary string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	host = asciiHost(strings.ToLower(strings.Split(host, ":")[0])) // remove possible port
+	stmt, args, err := sq.Select(
+		InstanceDomainDomainCol.identifier(),
+		InstanceDomainIsPrimaryCol.identifier(),
+	).
+		From(instanceDomainsTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.And{
+			sq.Eq{InstanceDomainInstanceIDCol.identifier(): authz.GetInstance(ctx).InstanceID()},
+			sq.Or{
+				sq.Eq{InstanceDomainDomainCol.identifier(): host},
+				sq.Eq{InstanceDomainIsPrimaryCol.identifier(): true},
+			},
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return false, "", zerrors.ThrowInternal(err, "QUERY-ung5E", "Errors.Query.SQLStatement")
+	}
+
+	var found bool
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				domain        string
+				domainPrimary bool
+			)
+			if err := rows.Scan(&domain, &domainPrimary); err != nil {
+				return err
+			}
+			if domain == host {
+				found = true
+			}
+			if domainPrimary {
+				primary = domain
+			}
+		}
+		return nil
+	}, stmt, args...)
+	if err != nil {
+		return false, "", zerrors.ThrowInternal(err, "QUERY-Eim2o", "Errors.Internal")
+	}
+	if !found {
+		return false, "", zerrors.ThrowNotFound(nil, "QUERY-ooS8e", "Errors.Instance.Domain.NotFound")
+	}
+	return primary == host, primary, nil
+}
+
+// WithExternalURL sets the protocol and port of the external URLs returned by [Queries.InstanceExternalURL].
+// They are configured for the whole system as ExternalSecure and ExternalPort.
+func WithExternalURL(secure bool, port uint16) QueryOption {
+	return func(q *Queries) {
+		q.externalSecure = secure
+		q.externalPort = port
+	}
+}
+
+// InstanceExternalURL returns the external URL (protocol://domain[:port]) of the instance in ctx.
+// The domain is the primary domain of the instance,
+// the protocol and port are set by [WithExternalURL]. The port is omitted if it is the default of the protocol.
+// If the instance has no primary domain, the host of the request in ctx is used as it was requested.
+func (q *Queries) InstanceExternalURL(ctx context.Context) (externalURL string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, args, err := sq.Select(InstanceDomainDomainCol.identifier()).
+		From(instanceDomainsTable.identifier() + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.Eq{
+			InstanceDomainInstanceIDCol.identifier(): authz.GetInstance(ctx).InstanceID(),
+			InstanceDomainIsPrimaryCol.identifier():  true,
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return "", zerrors.ThrowInternal(err, "QUERY-Baeh8", "Errors.Query.SQLStatement")
+	}
+
+	var primary string
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&primary)
+	}, stmt, args...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", zerrors.ThrowInternal(err, "QUERY-Eo4ae", "Errors.Internal")
+	}
+	if primary != "" {
+		return http_util.BuildHTTP(primary, q.externalPort, q.externalSecure), nil
+	}
+	requestedHost := http_util.DomainContext(ctx).RequestedHost()
+	if requestedHost == "" {
+		return "", zerrors.ThrowNotFound(nil, "QUERY-Ohr4e", "Errors.Instance.Domain.NotFound")
+	}
+	return http_util.BuildOrigin(requestedHost, q.externalSecure), nil
+}
+
 func (q *Queries) queryInstanceDomains(ctx context.Context, stmt string, scan func(*sql.Rows) (*InstanceDomains, error), args ...interface{}) (domains *InstanceDomains, err error) {
 	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
 		domains, err = scan(rows)
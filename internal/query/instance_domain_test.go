@@ -1,12 +1,21 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"regexp"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/api/authz"
+	http_util "github.com/zitadel/zitadel/internal/api/http"
+	"github.com/zitadel/zitadel/internal/database"
+	"github.com/zitadel/zitadel/internal/zerrors"
 )
 
 var (
@@ -171,3 +180,258 @@ func Test_InstanceDomainPrepares(t *testing.T) {
 		})
 	}
 }
+
+func TestQueries_HostsForInstance(t *testing.T) {
+	const hostsForInstanceStmt = `SELECT projections.instance_domains.domain` +
+		` FROM projections.instance_domains AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE projections.instance_domains.instance_id = $1`
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "generated domain only",
+			mock: mockQueries(regexp.QuoteMeta(hostsForInstanceStmt), []string{"domain"}, [][]driver.Value{
+				{"instance-abc.zitadel.cloud"},
+			}, "instanceID"),
+			want: []string{"instance-abc.zitadel.cloud"},
+		},
+		{
+			name: "multiple domains",
+			mock: mockQueries(regexp.QuoteMeta(hostsForInstanceStmt), []string{"domain"}, [][]driver.Value{
+				{"login.example.com"},
+				{"instance-abc.zitadel.cloud"},
+				{"Auth.Example.com"},
+				{"auth.example.com"},
+			}, "instanceID"),
+			want: []string{"auth.example.com", "instance-abc.zitadel.cloud", "login.example.com"},
+		},
+		{
+			name: "no domains",
+			mock: mockQueries(regexp.QuoteMeta(hostsForInstanceStmt), []string{"domain"}, nil, "instanceID"),
+			want: []string{},
+		},
+		{
+			name:    "sql error",
+			mock:    mockQueryErr(regexp.QuoteMeta(hostsForInstanceStmt), sql.ErrConnDone, "instanceID"),
+			wantErr: sql.ErrConnDone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.HostsForInstance(context.Background(), "instanceID")
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceRoutingFingerprint(t *testing.T) {
+	const routingStmt = `SELECT projections.instance_domains.domain, projections.instance_domains.is_primary` +
+		` FROM projections.instance_domains AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE projections.instance_domains.instance_id = $1`
+	fingerprint := func(t *testing.T, rows [][]driver.Value) string {
+		var got string
+		execMock(t, mockQueries(regexp.QuoteMeta(routingStmt), []string{"domain", "is_primary"}, rows, "instanceID"), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			var err error
+			got, err = q.InstanceRoutingFingerprint(context.Background(), "instanceID")
+			require.NoError(t, err)
+		})
+		return got
+	}
+
+	base := fingerprint(t, [][]driver.Value{
+		{"instance-abc.zitadel.cloud", false},
+		{"auth.example.com", true},
+	})
+	assert.NotEmpty(t, base)
+
+	t.Run("reordered", func(t *testing.T) {
+		assert.Equal(t, base, fingerprint(t, [][]driver.Value{
+			{"auth.example.com", true},
+			{"instance-abc.zitadel.cloud", false},
+		}))
+	})
+	t.Run("domain added", func(t *testing.T) {
+		assert.NotEqual(t, base, fingerprint(t, [][]driver.Value{
+			{"instance-abc.zitadel.cloud", false},
+			{"auth.example.com", true},
+			{"login.example.com", false},
+		}))
+	})
+	t.Run("domain removed", func(t *testing.T) {
+		assert.NotEqual(t, base, fingerprint(t, [][]driver.Value{
+			{"auth.example.com", true},
+		}))
+	})
+	t.Run("primary changed", func(t *testing.T) {
+		assert.NotEqual(t, base, fingerprint(t, [][]driver.Value{
+			{"instance-abc.zitadel.cloud", true},
+			{"auth.example.com", false},
+		}))
+	})
+}
+
+func TestQueries_IsPrimaryHost(t *testing.T) {
+	const isPrimaryHostStmt = `SELECT projections.instance_domains.domain, projections.instance_domains.is_primary` +
+		` FROM projections.instance_domains AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE (projections.instance_domains.instance_id = $1` +
+		` AND (projections.instance_domains.domain = $2 OR projections.instance_domains.is_primary = $3))`
+	cols := []string{"domain", "is_primary"}
+	tests := []struct {
+		name        string
+		host        string
+		mock        sqlExpectation
+		wantPrimary bool
+		want        string
+		wantErr     func(error) bool
+	}{
+		{
+			name: "primary host",
+			host: "auth.example.com:443",
+			mock: mockQueries(regexp.QuoteMeta(isPrimaryHostStmt), cols, [][]driver.Value{
+				{"auth.example.com", true},
+			}, "instanceID", "auth.example.com", true),
+			wantPrimary: true,
+			want:        "auth.example.com",
+		},
+		{
+			name: "non-primary host",
+			host: "instance-abc.zitadel.cloud",
+			mock: mockQueries(regexp.QuoteMeta(isPrimaryHostStmt), cols, [][]driver.Value{
+				{"instance-abc.zitadel.cloud", false},
+				{"auth.example.com", true},
+			}, "instanceID", "instance-abc.zitadel.cloud", true),
+			wantPrimary: false,
+			want:        "auth.example.com",
+		},
+		{
+			name: "upper case host",
+			host: "Auth.Example.COM",
+			mock: mockQueries(regexp.QuoteMeta(isPrimaryHostStmt), cols, [][]driver.Value{
+				{"auth.example.com", true},
+			}, "instanceID", "auth.example.com", true),
+			wantPrimary: true,
+			want:        "auth.example.com",
+		},
+		{
+			name: "internationalized host",
+			host: "Bücher.example.com:8080",
+			mock: mockQueries(regexp.QuoteMeta(isPrimaryHostStmt), cols, [][]driver.Value{
+				{"xn--bcher-kva.example.com", false},
+				{"auth.example.com", true},
+			}, "instanceID", "xn--bcher-kva.example.com", true),
+			wantPrimary: false,
+			want:        "auth.example.com",
+		},
+		{
+			name: "unknown host",
+			host: "unknown.example.com",
+			mock: mockQueries(regexp.QuoteMeta(isPrimaryHostStmt), cols, [][]driver.Value{
+				{"auth.example.com", true},
+			}, "instanceID", "unknown.example.com", true),
+			wantErr: zerrors.IsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				gotPrimary, got, err := q.IsPrimaryHost(authz.WithInstanceID(context.Background(), "instanceID"), tt.host)
+				if tt.wantErr != nil {
+					assert.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantPrimary, gotPrimary)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceExternalURL(t *testing.T) {
+	const primaryDomainStmt = `SELECT projections.instance_domains.domain` +
+		` FROM projections.instance_domains AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE projections.instance_domains.instance_id = $1 AND projections.instance_domains.is_primary = $2`
+	cols := []string{"domain"}
+	tests := []struct {
+		name      string
+		secure    bool
+		port      uint16
+		domainCtx *http_util.DomainCtx
+		mock      sqlExpectation
+		want      string
+		wantErr   func(error) bool
+	}{
+		{
+			name:      "primary domain",
+			secure:    true,
+			port:      443,
+			domainCtx: &http_util.DomainCtx{InstanceHost: "instance-abc.zitadel.cloud", Protocol: "https"},
+			mock:      mockQuery(regexp.QuoteMeta(primaryDomainStmt), cols, []driver.Value{"auth.example.com"}, "instanceID", true),
+			want:      "https://auth.example.com",
+		},
+		{
+			name:      "primary domain with configured port",
+			port:      8080,
+			domainCtx: &http_util.DomainCtx{InstanceHost: "localhost:8080", Protocol: "http"},
+			mock:      mockQuery(regexp.QuoteMeta(primaryDomainStmt), cols, []driver.Value{"auth.localhost"}, "instanceID", true),
+			want:      "http://auth.localhost:8080",
+		},
+		{
+			name:      "primary domain, request port ignored",
+			secure:    true,
+			port:      443,
+			domainCtx: &http_util.DomainCtx{InstanceHost: "[::1]:9000", Protocol: "http"},
+			mock:      mockQuery(regexp.QuoteMeta(primaryDomainStmt), cols, []driver.Value{"auth.example.com"}, "instanceID", true),
+			want:      "https://auth.example.com",
+		},
+		{
+			name:      "no primary domain, request host",
+			secure:    true,
+			port:      443,
+			domainCtx: &http_util.DomainCtx{InstanceHost: "instance-abc.zitadel.cloud", PublicHost: "login.example.com", Protocol: "https"},
+			mock:      mockQueryErr(regexp.QuoteMeta(primaryDomainStmt), sql.ErrNoRows, "instanceID", true),
+			want:      "https://login.example.com",
+		},
+		{
+			name:    "no primary domain, no request",
+			mock:    mockQueryErr(regexp.QuoteMeta(primaryDomainStmt), sql.ErrNoRows, "instanceID", true),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name:    "sql error",
+			mock:    mockQueryErr(regexp.QuoteMeta(primaryDomainStmt), sql.ErrConnDone, "instanceID", true),
+			wantErr: zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{
+					client:         &database.DB{DB: db, Database: new(prepareDB)},
+					externalSecure: tt.secure,
+					externalPort:   tt.port,
+				}
+				ctx := authz.WithInstanceID(context.Background(), "instanceID")
+				if tt.domainCtx != nil {
+					ctx = http_util.WithDomainContext(ctx, tt.domainCtx)
+				}
+				got, err := q.InstanceExternalURL(ctx)
+				if tt.wantErr != nil {
+					assert.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
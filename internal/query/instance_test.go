@@ -1,17 +1,45 @@
 package query
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"reflect"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	sq "github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/muhlemmer/gu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
 	"golang.org/x/text/language"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zitadel/zitadel/internal/api/authz"
+	"github.com/zitadel/zitadel/internal/cache"
+	"github.com/zitadel/zitadel/internal/cache/connector/gomap"
+	"github.com/zitadel/zitadel/internal/database"
+	db_mock "github.com/zitadel/zitadel/internal/database/mock"
+	"github.com/zitadel/zitadel/internal/domain"
+	"github.com/zitadel/zitadel/internal/eventstore"
+	"github.com/zitadel/zitadel/internal/eventstore/repository/mock"
+	instance_repo "github.com/zitadel/zitadel/internal/repository/instance"
+	"github.com/zitadel/zitadel/internal/repository/quota"
+	"github.com/zitadel/zitadel/internal/zerrors"
 )
 
 var (
@@ -25,6 +53,7 @@ var (
 		` projections.instances.console_client_id,` +
 		` projections.instances.console_app_id,` +
 		` projections.instances.default_language,` +
+		` projections.limits.audit_log_retention,` +
 		` projections.instance_domains.domain,` +
 		` projections.instance_domains.is_primary,` +
 		` projections.instance_domains.is_generated,` +
@@ -34,8 +63,11 @@ var (
 		` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances` +
 		` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f` +
 		` LEFT JOIN projections.instances ON f.id = projections.instances.id` +
+		` LEFT JOIN projections.limits ON f.id = projections.limits.instance_id` +
 		` LEFT JOIN projections.instance_domains ON f.id = projections.instance_domains.instance_id` +
 		` AS OF SYSTEM TIME '-1 ms'`
+	instancesFilterQuery = ` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances` +
+		` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f`
 	instancesCols = []string{
 		"count",
 		"id",
@@ -48,6 +80,7 @@ var (
 		"console_client_id",
 		"console_app_id",
 		"default_language",
+		"audit_log_retention",
 		"domain",
 		"is_primary",
 		"is_generated",
@@ -107,6 +140,7 @@ func Test_InstancePrepares(t *testing.T) {
 							"client-id",
 							"app-id",
 							"en",
+							nil,
 							"test.zitadel.cloud",
 							true,
 							true,
@@ -171,6 +205,7 @@ func Test_InstancePrepares(t *testing.T) {
 							"client-id",
 							"app-id",
 							"en",
+							nil,
 							"test.zitadel.cloud",
 							false,
 							true,
@@ -190,6 +225,7 @@ func Test_InstancePrepares(t *testing.T) {
 							"client-id",
 							"app-id",
 							"en",
+							nil,
 							"zitadel.cloud",
 							true,
 							false,
@@ -209,6 +245,7 @@ func Test_InstancePrepares(t *testing.T) {
 							"client-id",
 							"app-id",
 							"en",
+							time.Hour * 24 * 30,
 							"test2.zitadel.cloud",
 							true,
 							true,
@@ -256,16 +293,17 @@ func Test_InstancePrepares(t *testing.T) {
 							},
 						},
 					}, {
-						ID:           "id2",
-						CreationDate: testNow,
-						ChangeDate:   testNow,
-						Sequence:     20211108,
-						Name:         "test2",
-						DefaultOrgID: "global-org-id",
-						IAMProjectID: "project-id",
-						ConsoleID:    "client-id",
-						ConsoleAppID: "app-id",
-						DefaultLang:  language.English,
+						ID:                "id2",
+						CreationDate:      testNow,
+						ChangeDate:        testNow,
+						Sequence:          20211108,
+						Name:              "test2",
+						DefaultOrgID:      "global-org-id",
+						IAMProjectID:      "project-id",
+						ConsoleID:         "client-id",
+						ConsoleAppID:      "app-id",
+						DefaultLang:       language.English,
+						AuditLogRetention: gu.Ptr(time.Hour * 24 * 30),
 						Domains: []*InstanceDomain{
 							{
 								CreationDate: testNow,
@@ -308,3 +346,3778 @@ func Test_InstancePrepares(t *testing.T) {
 		})
 	}
 }
+
+var (
+	authzInstanceCols = []string{
+		"id",
+		"default_org_id",
+		"iam_project_id",
+		"console_client_id",
+		"console_app_id",
+		"default_language",
+		"enable_iframe_embedding",
+		"origins",
+		"enable_impersonation",
+		"audit_log_retention",
+		"block",
+		"features",
+		"external_domains",
+		"trusted_domains",
+	}
+)
+
+func TestQueries_InstanceByID_refreshAhead(t *testing.T) {
+	const refreshDelay = 200 * time.Millisecond
+	execMock(t,
+		func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+			m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+				WithArgs("instanceID").
+				WillDelayFor(refreshDelay).
+				WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+					"instanceID", "refreshed-org-id", "project-id", "client-id", "app-id", "en",
+					nil, nil, nil, nil, nil, nil, []string{"test.zitadel.cloud"}, nil,
+				))
+			return m
+		},
+		func(db *sql.DB) {
+			ctx := context.Background()
+			q := &Queries{
+				client: &database.DB{
+					DB:       db,
+					Database: new(prepareDB),
+				},
+				caches: &Caches{
+					instance:       gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+					instanceMaxAge: time.Minute,
+				},
+				instanceCacheRefreshAhead: 10 * time.Second,
+			}
+			q.caches.instance.Set(ctx, &authzInstance{
+				ID:           "instanceID",
+				DefaultOrgID: "cached-org-id",
+				CachedAt:     time.Now().Add(-time.Minute),
+			})
+
+			start := time.Now()
+			got, err := q.InstanceByID(ctx, "instanceID")
+			require.NoError(t, err)
+			assert.Less(t, time.Since(start), refreshDelay, "read must not wait for the refresh")
+			assert.Equal(t, "cached-org-id", got.DefaultOrganisationID())
+
+			assert.Eventually(t, func() bool {
+				cached, ok := q.caches.instance.Get(ctx, instanceIndexByID, "instanceID")
+				return ok && cached.DefaultOrgID == "refreshed-org-id"
+			}, 5*time.Second, 10*time.Millisecond)
+			_, running := q.caches.instanceRefreshing.Load("instanceID")
+			assert.False(t, running)
+		},
+	)
+}
+
+func TestQueries_InstanceBySNI(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverName string
+		wantHost   string
+		wantErr    func(error) bool
+	}{
+		{
+			name:       "lower cased",
+			serverName: "Instance.Zitadel.Cloud",
+			wantHost:   "instance.zitadel.cloud",
+		},
+		{
+			name:       "trailing dot",
+			serverName: "instance.zitadel.cloud.",
+			wantHost:   "instance.zitadel.cloud",
+		},
+		{
+			name:       "idn",
+			serverName: "bücher.example.com",
+			wantHost:   "xn--bcher-kva.example.com",
+		},
+		{
+			name:       "punycode",
+			serverName: "xn--bcher-kva.example.com",
+			wantHost:   "xn--bcher-kva.example.com",
+		},
+		{
+			name:       "invalid",
+			serverName: "in valid.example.com",
+			wantErr:    zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t,
+				func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+					if tt.wantErr != nil {
+						return m
+					}
+					m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+						WithArgs(tt.wantHost).
+						WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+							"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+							nil, nil, nil, nil, nil, nil, []string{tt.wantHost}, nil,
+						))
+					return m
+				},
+				func(db *sql.DB) {
+					ctx := context.Background()
+					q := &Queries{
+						client: &database.DB{DB: db, Database: new(prepareDB)},
+						caches: &Caches{
+							instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+						},
+					}
+					got, err := q.InstanceBySNI(ctx, tt.serverName)
+					if tt.wantErr != nil {
+						require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+						return
+					}
+					require.NoError(t, err)
+					assert.Equal(t, "instanceID", got.InstanceID())
+				},
+			)
+		})
+	}
+}
+
+func TestQueries_InstanceByHost_idn(t *testing.T) {
+	const storedDomain = "xn--mnchen-3ya.example"
+	tests := []struct {
+		name         string
+		instanceHost string
+		publicHost   string
+	}{
+		{
+			name:         "unicode host",
+			instanceHost: "münchen.example",
+		},
+		{
+			name:         "unicode host with port",
+			instanceHost: "münchen.example:8080",
+		},
+		{
+			name:         "punycode host",
+			instanceHost: "xn--mnchen-3ya.example",
+		},
+		{
+			name:         "unicode public host",
+			instanceHost: "xn--mnchen-3ya.example",
+			publicHost:   "münchen.example",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t,
+				func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+					m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+						WithArgs(storedDomain).
+						WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+							"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+							nil, nil, nil, nil, nil, nil, []string{storedDomain}, nil,
+						))
+					return m
+				},
+				func(db *sql.DB) {
+					ctx := context.Background()
+					q := &Queries{
+						client: &database.DB{DB: db, Database: new(prepareDB)},
+						caches: &Caches{
+							instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+						},
+					}
+					got, err := q.InstanceByHost(ctx, tt.instanceHost, tt.publicHost)
+					require.NoError(t, err)
+					assert.Equal(t, "instanceID", got.InstanceID())
+
+					// the cache is indexed by the stored domain and must resolve the unicode form as well
+					got, err = q.InstanceByHost(ctx, "münchen.example", "")
+					require.NoError(t, err)
+					assert.Equal(t, "instanceID", got.InstanceID())
+				},
+			)
+		})
+	}
+}
+
+func TestQueries_InstanceByHostOrFallback(t *testing.T) {
+	authzInstanceRow := func(id string) []driver.Value {
+		return []driver.Value{
+			id, "org-id", "project-id", "client-id", "app-id", "en",
+			nil, nil, nil, nil, nil, nil, []string{id + ".zitadel.cloud"}, nil,
+		}
+	}
+	tests := []struct {
+		name               string
+		fallbackInstanceID string
+		mock               sqlExpectation
+		wantID             string
+		wantFallback       bool
+		wantErr            func(error) bool
+	}{
+		{
+			name:               "resolved host",
+			fallbackInstanceID: "fallback",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+					WithArgs("instance.zitadel.cloud").
+					WillReturnRows(m.NewRows(authzInstanceCols).AddRow(authzInstanceRow("instance")...))
+				return m
+			},
+			wantID: "instance",
+		},
+		{
+			name:               "unknown host",
+			fallbackInstanceID: "fallback",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+					WithArgs("instance.zitadel.cloud").
+					WillReturnRows(m.NewRows(authzInstanceCols))
+				m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+					WithArgs("fallback").
+					WillReturnRows(m.NewRows(authzInstanceCols).AddRow(authzInstanceRow("fallback")...))
+				return m
+			},
+			wantID:       "fallback",
+			wantFallback: true,
+		},
+		{
+			name: "unknown host without fallback",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+					WithArgs("instance.zitadel.cloud").
+					WillReturnRows(m.NewRows(authzInstanceCols))
+				return m
+			},
+			wantErr: func(err error) bool {
+				notFoundErr := new(zerrors.NotFoundError)
+				return errors.As(err, &notFoundErr) && ErrorHasID(err, "QUERY-1kIjX")
+			},
+		},
+		{
+			name: "invalid features",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				row := authzInstanceRow("instance")
+				row[11] = []byte("{")
+				m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+					WithArgs("instance.zitadel.cloud").
+					WillReturnRows(m.NewRows(authzInstanceCols).AddRow(row...))
+				return m
+			},
+			wantErr: func(err error) bool {
+				var zitadelErr *zerrors.ZitadelError
+				// the scan error is returned without wrapping it again
+				return errors.As(err, &zitadelErr) && zitadelErr.GetID() == "QUERY-Po8ki"
+			},
+		},
+		{
+			name:               "database error",
+			fallbackInstanceID: "fallback",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+					WithArgs("instance.zitadel.cloud").
+					WillReturnError(sql.ErrConnDone)
+				return m
+			},
+			wantErr: func(err error) bool {
+				return errors.Is(err, sql.ErrConnDone)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				ctx := context.Background()
+				q := &Queries{
+					client: &database.DB{DB: db, Database: new(prepareDB)},
+					caches: &Caches{
+						instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+					},
+				}
+				got, fallback, err := q.InstanceByHostOrFallback(ctx, "instance.zitadel.cloud", tt.fallbackInstanceID)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantID, got.InstanceID())
+				assert.Equal(t, tt.wantFallback, fallback)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceTrustedDomains(t *testing.T) {
+	tests := []struct {
+		name            string
+		externalDomains []string
+		trustedDomains  []string
+		want            []string
+	}{
+		{
+			name:            "overlapping domains",
+			externalDomains: []string{"acme.zitadel.cloud", "Login.Acme.ch"},
+			trustedDomains:  []string{"login.acme.ch", "acme.ch", "acme.zitadel.cloud"},
+			want:            []string{"acme.ch", "acme.zitadel.cloud", "login.acme.ch"},
+		},
+		{
+			name:            "without trusted domains",
+			externalDomains: []string{"acme.zitadel.cloud"},
+			want:            []string{"acme.zitadel.cloud"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t,
+				func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+					var trustedDomains driver.Value
+					if tt.trustedDomains != nil {
+						trustedDomains = tt.trustedDomains
+					}
+					m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+						WithArgs("instanceID").
+						WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+							"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+							nil, nil, nil, nil, nil, nil, tt.externalDomains, trustedDomains,
+						))
+					return m
+				},
+				func(db *sql.DB) {
+					ctx := authz.WithInstanceID(context.Background(), "instanceID")
+					q := &Queries{
+						client: &database.DB{DB: db, Database: new(prepareDB)},
+						caches: &Caches{
+							instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+						},
+					}
+					got, err := q.InstanceTrustedDomains(ctx)
+					require.NoError(t, err)
+					assert.Equal(t, tt.want, got)
+				},
+			)
+		})
+	}
+}
+
+func TestQueries_InstanceByAPIKeyPrefix(t *testing.T) {
+	keyInstanceQuery := `SELECT projections.authn_keys2.instance_id FROM projections.authn_keys2 WHERE projections.authn_keys2.id = $1`
+	tests := []struct {
+		name    string
+		prefix  string
+		mock    sqlExpectation
+		want    string
+		wantErr func(error) bool
+	}{
+		{
+			name:   "valid prefix",
+			prefix: " key-id ",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(keyInstanceQuery)).
+					WithArgs("key-id").
+					WillReturnRows(m.NewRows([]string{"instance_id"}).AddRow("instanceID"))
+				m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+					WithArgs("instanceID").
+					WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+						"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+						nil, nil, nil, nil, nil, nil, []string{"instance.zitadel.cloud"}, nil,
+					))
+				return m
+			},
+			want: "instanceID",
+		},
+		{
+			name:   "unknown prefix",
+			prefix: "unknown",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(keyInstanceQuery)).
+					WithArgs("unknown").
+					WillReturnRows(m.NewRows([]string{"instance_id"}))
+				return m
+			},
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name:    "empty prefix",
+			prefix:  " ",
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				ctx := context.Background()
+				q := &Queries{
+					client: &database.DB{DB: db, Database: new(prepareDB)},
+					caches: &Caches{
+						instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+					},
+				}
+				got, err := q.InstanceByAPIKeyPrefix(ctx, tt.prefix)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got.InstanceID())
+			})
+		})
+	}
+}
+
+func TestQueries_WithInstanceContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		wantErr func(error) bool
+	}{
+		{
+			name: "found",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+					WithArgs("instanceID").
+					WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+						"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+						nil, nil, nil, nil, nil, nil, []string{"instance.zitadel.cloud"}, nil,
+					))
+				return mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{instanceRow("instanceID", "instance.zitadel.cloud")[1:]}, "instanceID")(m)
+			},
+		},
+		{
+			name: "missing",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				m.ExpectQuery(regexp.QuoteMeta(instanceByIDQuery)).
+					WithArgs("instanceID").
+					WillReturnRows(m.NewRows(authzInstanceCols))
+				return m
+			},
+			wantErr: zerrors.IsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				ctx := context.Background()
+				q := &Queries{
+					client: &database.DB{DB: db, Database: new(prepareDB)},
+					caches: &Caches{
+						instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+					},
+				}
+				jobCtx, err := q.WithInstanceContext(ctx, "instanceID")
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, "instanceID", authz.GetInstance(jobCtx).InstanceID())
+				assert.Equal(t, "project-id", authz.GetInstance(jobCtx).ProjectID())
+
+				instance, err := q.Instance(jobCtx, false)
+				require.NoError(t, err)
+				assert.Equal(t, "instanceID", instance.ID)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceFromGRPCMetadata(t *testing.T) {
+	const headerKey = "x-zitadel-tenant-host"
+	tests := []struct {
+		name     string
+		metadata metadata.MD
+		wantHost string
+		wantErr  func(error) bool
+	}{
+		{
+			name:     "present",
+			metadata: metadata.Pairs(headerKey, " Instance.Zitadel.Cloud:443 "),
+			wantHost: "instance.zitadel.cloud",
+		},
+		{
+			name:     "same host multiple times",
+			metadata: metadata.Pairs(headerKey, "instance.zitadel.cloud", headerKey, "INSTANCE.zitadel.cloud"),
+			wantHost: "instance.zitadel.cloud",
+		},
+		{
+			name:     "different hosts",
+			metadata: metadata.Pairs(headerKey, "instance.zitadel.cloud", headerKey, "other.zitadel.cloud"),
+			wantErr:  zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:     "absent",
+			metadata: metadata.Pairs("x-other", "instance.zitadel.cloud"),
+			wantErr:  zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:     "empty",
+			metadata: metadata.Pairs(headerKey, " "),
+			wantErr:  zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t,
+				func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+					if tt.wantErr != nil {
+						return m
+					}
+					m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+						WithArgs(tt.wantHost).
+						WillReturnRows(m.NewRows(authzInstanceCols).AddRow(
+							"instanceID", "org-id", "project-id", "client-id", "app-id", "en",
+							nil, nil, nil, nil, nil, nil, []string{tt.wantHost}, nil,
+						))
+					return m
+				},
+				func(db *sql.DB) {
+					ctx := metadata.NewIncomingContext(context.Background(), tt.metadata)
+					q := &Queries{
+						client: &database.DB{DB: db, Database: new(prepareDB)},
+						caches: &Caches{
+							instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+						},
+					}
+					got, err := q.InstanceFromGRPCMetadata(ctx, headerKey)
+					if tt.wantErr != nil {
+						require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+						return
+					}
+					require.NoError(t, err)
+					assert.Equal(t, "instanceID", got.InstanceID())
+				},
+			)
+		})
+	}
+}
+
+func TestQueries_InstancesByHostStrict(t *testing.T) {
+	authzInstanceRow := func(id string) []driver.Value {
+		return []driver.Value{
+			id, "org-id", "project-id", "client-id", "app-id", "en",
+			nil, nil, nil, nil, nil, nil, []string{"shared.zitadel.cloud"}, nil,
+		}
+	}
+	tests := []struct {
+		name            string
+		rows            [][]driver.Value
+		wantIDs         []string
+		wantErr         func(error) bool
+		wantInstanceID  string
+		wantInstanceErr func(error) bool
+	}{
+		{
+			name:           "single match",
+			rows:           [][]driver.Value{authzInstanceRow("instance")},
+			wantIDs:        []string{"instance"},
+			wantInstanceID: "instance",
+		},
+		{
+			name:    "conflict",
+			rows:    [][]driver.Value{authzInstanceRow("instance1"), authzInstanceRow("instance2")},
+			wantIDs: []string{"instance1", "instance2"},
+			wantInstanceErr: func(err error) bool {
+				internalErr := new(zerrors.InternalError)
+				return errors.As(err, &internalErr)
+			},
+		},
+		{
+			name: "not found",
+			wantErr: func(err error) bool {
+				notFoundErr := new(zerrors.NotFoundError)
+				return errors.As(err, &notFoundErr)
+			},
+			wantInstanceErr: func(err error) bool {
+				notFoundErr := new(zerrors.NotFoundError)
+				return errors.As(err, &notFoundErr)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t,
+				func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+					for i := 0; i < 2; i++ {
+						rows := m.NewRows(authzInstanceCols)
+						for _, row := range tt.rows {
+							rows.AddRow(row...)
+						}
+						m.ExpectQuery(regexp.QuoteMeta(instanceByDomainQuery)).
+							WithArgs("shared.zitadel.cloud").
+							WillReturnRows(rows)
+					}
+					return m
+				},
+				func(db *sql.DB) {
+					ctx := context.Background()
+					q := &Queries{
+						client: &database.DB{DB: db, Database: new(prepareDB)},
+						caches: &Caches{
+							instance: gomap.NewCache[instanceIndex, string, *authzInstance](ctx, instanceIndexValues(), cache.Config{MaxAge: time.Minute}),
+						},
+					}
+					got, err := q.InstancesByHostStrict(ctx, "shared.zitadel.cloud:443")
+					if tt.wantErr != nil {
+						require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					} else {
+						require.NoError(t, err)
+						ids := make([]string, len(got))
+						for i, instance := range got {
+							ids[i] = instance.InstanceID()
+						}
+						assert.Equal(t, tt.wantIDs, ids)
+					}
+
+					instance, err := q.InstanceByHost(ctx, "shared.zitadel.cloud", "")
+					if tt.wantInstanceErr != nil {
+						require.True(t, tt.wantInstanceErr(err), "unexpected error: %v", err)
+						return
+					}
+					require.NoError(t, err)
+					assert.Equal(t, tt.wantInstanceID, instance.InstanceID())
+				},
+			)
+		})
+	}
+}
+
+func Test_asciiHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "instance.zitadel.cloud", want: "instance.zitadel.cloud"},
+		{host: "Instance.Zitadel.Cloud", want: "Instance.Zitadel.Cloud"},
+		{host: "münchen.example", want: "xn--mnchen-3ya.example"},
+		{host: "xn--mnchen-3ya.example", want: "xn--mnchen-3ya.example"},
+		{host: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			assert.Equal(t, tt.want, asciiHost(tt.host))
+		})
+	}
+}
+
+func TestQueries_instanceRefreshDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   time.Duration
+		maxAge   time.Duration
+		cachedAt time.Time
+		want     bool
+	}{
+		{
+			name:     "disabled",
+			maxAge:   time.Minute,
+			cachedAt: time.Now().Add(-time.Minute),
+			want:     false,
+		},
+		{
+			name:     "no max age",
+			window:   time.Second,
+			cachedAt: time.Now().Add(-time.Hour),
+			want:     false,
+		},
+		{
+			name:   "not cached",
+			window: time.Second,
+			maxAge: time.Minute,
+			want:   false,
+		},
+		{
+			name:     "fresh",
+			window:   10 * time.Second,
+			maxAge:   time.Minute,
+			cachedAt: time.Now(),
+			want:     false,
+		},
+		{
+			name:     "within window",
+			window:   10 * time.Second,
+			maxAge:   time.Minute,
+			cachedAt: time.Now().Add(-time.Minute),
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Queries{
+				caches:                    &Caches{instanceMaxAge: tt.maxAge},
+				instanceCacheRefreshAhead: tt.window,
+			}
+			got := q.instanceRefreshDue(&authzInstance{CachedAt: tt.cachedAt})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func instanceRow(id, domain string) []driver.Value {
+	return []driver.Value{
+		uint64(3), id, testNow, testNow, uint64(20211108), "name-" + id,
+		"global-org-id", "project-id", "client-id", "app-id", "en", nil,
+		domain, true, true, testNow, testNow, uint64(20211108),
+	}
+}
+
+func TestQueries_SearchInstancesChan(t *testing.T) {
+	rows := [][]driver.Value{
+		instanceRow("id1", "one.zitadel.cloud"),
+		instanceRow("id1", "one.example.com"),
+		instanceRow("id2", "two.zitadel.cloud"),
+		instanceRow("id3", "three.zitadel.cloud"),
+	}
+
+	t.Run("read all", func(t *testing.T) {
+		execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			instances, errs := q.SearchInstancesChan(context.Background(), &InstanceSearchQueries{})
+
+			var ids []string
+			for instance := range instances {
+				ids = append(ids, instance.ID)
+				if instance.ID == "id1" {
+					assert.Len(t, instance.Domains, 2)
+				}
+			}
+			require.NoError(t, <-errs)
+			assert.Equal(t, []string{"id1", "id2", "id3"}, ids)
+		})
+	})
+
+	t.Run("cancel after first", func(t *testing.T) {
+		execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			ctx, cancel := context.WithCancel(context.Background())
+			instances, errs := q.SearchInstancesChan(ctx, &InstanceSearchQueries{})
+
+			first := <-instances
+			assert.Equal(t, "id1", first.ID)
+			cancel()
+
+			select {
+			case err := <-errs:
+				require.ErrorIs(t, err, context.Canceled)
+			case <-time.After(5 * time.Second):
+				t.Fatal("producer did not stop after cancel")
+			}
+			_, ok := <-instances
+			assert.False(t, ok, "data channel must be closed")
+		})
+	})
+
+	t.Run("consumer stops reading", func(t *testing.T) {
+		execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			ctx, cancel := context.WithCancel(context.Background())
+			instances, errs := q.SearchInstancesChan(ctx, &InstanceSearchQueries{})
+
+			// the producer is blocked on sending the first instance
+			assert.Eventually(t, func() bool { return db.Stats().InUse == 1 }, 5*time.Second, 10*time.Millisecond)
+			cancel()
+
+			select {
+			case err := <-errs:
+				require.ErrorIs(t, err, context.Canceled)
+			case <-time.After(5 * time.Second):
+				t.Fatal("producer did not stop after cancel")
+			}
+			_, ok := <-errs
+			assert.False(t, ok, "error channel must be closed")
+			assert.Zero(t, db.Stats().InUse, "connection must be released")
+			_, ok = <-instances
+			assert.False(t, ok, "data channel must be closed")
+		})
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		emptyIDsQuery, err := NewInstanceIDsListSearchQuery()
+		require.NoError(t, err)
+		tests := []struct {
+			name          string
+			maxResultSize uint64
+			queries       *InstanceSearchQueries
+		}{
+			{
+				name:    "empty ids",
+				queries: &InstanceSearchQueries{Queries: []SearchQuery{emptyIDsQuery}},
+			},
+			{
+				name:          "limit exceeds max",
+				maxResultSize: 2,
+				queries:       &InstanceSearchQueries{SearchRequest: SearchRequest{Limit: 3}},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				execMock(t, func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m }, func(db *sql.DB) {
+					q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}, maxInstanceResultSize: tt.maxResultSize}
+					instances, errs := q.SearchInstancesChan(context.Background(), tt.queries)
+
+					_, ok := <-instances
+					assert.False(t, ok, "data channel must be closed")
+					err := <-errs
+					assert.True(t, zerrors.IsErrorInvalidArgument(err), "want invalid argument, got %v", err)
+					_, ok = <-errs
+					assert.False(t, ok, "error channel must be closed")
+				})
+			})
+		}
+	})
+}
+
+func TestQueries_ExistingInstanceIDs(t *testing.T) {
+	const existingInstanceIDsQuery = `SELECT projections.instances.id FROM projections.instances AS OF SYSTEM TIME '-1 ms' WHERE projections.instances.id IN ($1,$2,$3,$4,$5)`
+	t.Run("existing and missing ids", func(t *testing.T) {
+		execMock(t,
+			mockQueries(regexp.QuoteMeta(existingInstanceIDsQuery), []string{"id"},
+				[][]driver.Value{{"id1"}, {"id3"}, {"id5"}},
+				"id5", "id2", "id1", "id4", "id3",
+			),
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.ExistingInstanceIDs(context.Background(), "id5", "id2", "id1", "id4", "id3")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"id5", "id1", "id3"}, got)
+			},
+		)
+	})
+
+	t.Run("no ids", func(t *testing.T) {
+		execMock(t, func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m }, func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			got, err := q.ExistingInstanceIDs(context.Background())
+			require.NoError(t, err)
+			assert.Empty(t, got)
+		})
+	})
+}
+
+func TestQueries_InstancesWithoutDomains(t *testing.T) {
+	const instancesWithoutDomainsQuery = `SELECT projections.instances.id,` +
+		` projections.instances.creation_date,` +
+		` projections.instances.change_date,` +
+		` projections.instances.sequence,` +
+		` projections.instances.name,` +
+		` projections.instances.default_org_id,` +
+		` projections.instances.iam_project_id,` +
+		` projections.instances.console_client_id,` +
+		` projections.instances.console_app_id,` +
+		` projections.instances.default_language` +
+		` FROM projections.instances AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE NOT EXISTS (SELECT 1 FROM projections.instance_domains WHERE projections.instance_domains.instance_id = projections.instances.id)` +
+		` ORDER BY projections.instances.id`
+	// the healthy instance is filtered by the database, so only the orphan is returned
+	orphan := instanceRow("orphan", "")[1:11]
+
+	execMock(t, mockQueries(regexp.QuoteMeta(instancesWithoutDomainsQuery), instanceCols[:10], [][]driver.Value{orphan}), func(db *sql.DB) {
+		q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+		got, err := q.InstancesWithoutDomains(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, &Instances{
+			SearchResponse: SearchResponse{Count: 1},
+			Instances: []*Instance{
+				{
+					ID:           "orphan",
+					CreationDate: testNow,
+					ChangeDate:   testNow,
+					Sequence:     20211108,
+					Name:         "name-orphan",
+					DefaultOrgID: "global-org-id",
+					IAMProjectID: "project-id",
+					ConsoleID:    "client-id",
+					ConsoleAppID: "app-id",
+					DefaultLang:  language.English,
+					Domains:      []*InstanceDomain{},
+				},
+			},
+		}, got)
+	})
+}
+
+func TestQueries_InstanceConsoleRedirectURIs(t *testing.T) {
+	const consoleRedirectURIsQuery = `SELECT projections.apps7_oidc_configs.redirect_uris FROM projections.apps7_oidc_configs WHERE projections.apps7_oidc_configs.app_id = $1 AND projections.apps7_oidc_configs.instance_id = $2`
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		mock    sqlExpectation
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "no console app",
+			ctx:  authz.WithInstanceID(context.Background(), "instanceID"),
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			want: []string{},
+		},
+		{
+			name: "console app not found",
+			ctx:  authz.WithConsole(authz.WithInstanceID(context.Background(), "instanceID"), "projectID", "appID"),
+			mock: mockQueryErr(regexp.QuoteMeta(consoleRedirectURIsQuery), sql.ErrNoRows, "appID", "instanceID"),
+			want: []string{},
+		},
+		{
+			name: "configured console app",
+			ctx:  authz.WithConsole(authz.WithInstanceID(context.Background(), "instanceID"), "projectID", "appID"),
+			mock: mockQuery(regexp.QuoteMeta(consoleRedirectURIsQuery),
+				[]string{"redirect_uris"},
+				[]driver.Value{[]string{"https://test.zitadel.cloud/ui/console/auth/callback", "http://localhost:4200/auth/callback"}},
+				"appID", "instanceID",
+			),
+			want: []string{"https://test.zitadel.cloud/ui/console/auth/callback", "http://localhost:4200/auth/callback"},
+		},
+		{
+			name:    "sql error",
+			ctx:     authz.WithConsole(authz.WithInstanceID(context.Background(), "instanceID"), "projectID", "appID"),
+			mock:    mockQueryErr(regexp.QuoteMeta(consoleRedirectURIsQuery), sql.ErrConnDone, "appID", "instanceID"),
+			wantErr: sql.ErrConnDone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceConsoleRedirectURIs(tt.ctx)
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceConsoleOIDCConfig(t *testing.T) {
+	consoleAppQuery := expectedAppQuery + regexp.QuoteMeta(` AS OF SYSTEM TIME '-1 ms' WHERE projections.apps7.id = $1 AND projections.apps7.instance_id = $2`)
+	consoleAppRow := func(oidc bool) []driver.Value {
+		row := []driver.Value{"appID", "console", "projectID", testNow, testNow, "ro", domain.AppStateActive, uint64(20211109)}
+		if !oidc {
+			// api config
+			row = append(row, "appID", "api-client-id", domain.APIAuthMethodTypePrivateKeyJWT)
+			// oidc config
+			row = append(row, make([]driver.Value, 18)...)
+		} else {
+			// api config
+			row = append(row, nil, nil, nil)
+			// oidc config
+			row = append(row,
+				"appID",
+				domain.OIDCVersionV1,
+				"console-client-id",
+				database.TextArray[string]{"https://instance.zitadel.cloud/ui/console/auth/callback"},
+				database.NumberArray[domain.OIDCResponseType]{domain.OIDCResponseTypeCode},
+				database.NumberArray[domain.OIDCGrantType]{domain.OIDCGrantTypeAuthorizationCode},
+				domain.OIDCApplicationTypeUserAgent,
+				domain.OIDCAuthMethodTypeNone,
+				database.TextArray[string]{"https://instance.zitadel.cloud/ui/console/signedout"},
+				false,
+				domain.OIDCTokenTypeBearer,
+				false,
+				false,
+				false,
+				time.Duration(0),
+				database.TextArray[string]{},
+				false,
+				"",
+			)
+		}
+		// saml config
+		return append(row, nil, nil, nil, nil)
+	}
+	consoleCtx := authz.WithConsole(authz.WithInstanceID(context.Background(), "instanceID"), "projectID", "appID")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		mock    sqlExpectation
+		want    *OIDCApp
+		wantErr func(error) bool
+	}{
+		{
+			name:    "no console app",
+			ctx:     authz.WithInstanceID(context.Background(), "instanceID"),
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name:    "console app not found",
+			ctx:     consoleCtx,
+			mock:    mockQueryErr(consoleAppQuery, sql.ErrNoRows, "appID", "instanceID"),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name:    "console app without oidc config",
+			ctx:     consoleCtx,
+			mock:    mockQuery(consoleAppQuery, appCols, consoleAppRow(false), "appID", "instanceID"),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name: "configured console app",
+			ctx:  consoleCtx,
+			mock: mockQuery(consoleAppQuery, appCols, consoleAppRow(true), "appID", "instanceID"),
+			want: &OIDCApp{
+				Version:                domain.OIDCVersionV1,
+				ClientID:               "console-client-id",
+				RedirectURIs:           database.TextArray[string]{"https://instance.zitadel.cloud/ui/console/auth/callback"},
+				ResponseTypes:          database.NumberArray[domain.OIDCResponseType]{domain.OIDCResponseTypeCode},
+				GrantTypes:             database.NumberArray[domain.OIDCGrantType]{domain.OIDCGrantTypeAuthorizationCode},
+				AppType:                domain.OIDCApplicationTypeUserAgent,
+				AuthMethodType:         domain.OIDCAuthMethodTypeNone,
+				PostLogoutRedirectURIs: database.TextArray[string]{"https://instance.zitadel.cloud/ui/console/signedout"},
+				AccessTokenType:        domain.OIDCTokenTypeBearer,
+				AdditionalOrigins:      database.TextArray[string]{},
+				AllowedOrigins:         database.TextArray[string]{"https://instance.zitadel.cloud"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceConsoleOIDCConfig(tt.ctx)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceFull(t *testing.T) {
+	projectQuery := regexp.QuoteMeta(prepareProjectStmt + ` WHERE projections.projects4.id = $1 AND projections.projects4.instance_id = $2`)
+	appQuery := expectedAppQuery + regexp.QuoteMeta(` AS OF SYSTEM TIME '-1 ms' WHERE projections.apps7.id = $1 AND projections.apps7.instance_id = $2`)
+	projectRow := []driver.Value{"project-id", testNow, testNow, "global-org-id", domain.ProjectStateActive, uint64(20211108), "ZITADEL", false, false, false, domain.PrivateLabelingSettingUnspecified}
+	appRow := func(projectID string) []driver.Value {
+		row := []driver.Value{"app-id", "console", projectID, testNow, testNow, "global-org-id", domain.AppStateActive, uint64(20211109)}
+		// api config
+		row = append(row, "app-id", "api-client-id", domain.APIAuthMethodTypePrivateKeyJWT)
+		// oidc and saml config
+		return append(row, make([]driver.Value, 22)...)
+	}
+	ctx := authz.WithInstanceID(context.Background(), "instanceID")
+
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		wantErr func(error) bool
+	}{
+		{
+			name:    "instance not found",
+			mock:    mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, nil, "instanceID"),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name: "project not found",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")[1:]}, "instanceID")(m)
+				return mockQueryErr(projectQuery, sql.ErrNoRows, "project-id", "instanceID")(m)
+			},
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name: "console app of other project",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")[1:]}, "instanceID")(m)
+				mockQuery(projectQuery, prepareProjectCols, projectRow, "project-id", "instanceID")(m)
+				return mockQuery(appQuery, appCols, appRow("other-project-id"), "app-id", "instanceID")(m)
+			},
+			wantErr: zerrors.IsInternal,
+		},
+		{
+			name: "found",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")[1:]}, "instanceID")(m)
+				mockQuery(projectQuery, prepareProjectCols, projectRow, "project-id", "instanceID")(m)
+				return mockQuery(appQuery, appCols, appRow("project-id"), "app-id", "instanceID")(m)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceFull(ctx)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, "instanceID", got.ID)
+				require.NotNil(t, got.Project)
+				require.NotNil(t, got.ConsoleApp)
+				assert.Equal(t, got.IAMProjectID, got.Project.ID)
+				assert.Equal(t, got.ConsoleAppID, got.ConsoleApp.ID)
+				assert.Equal(t, got.Project.ID, got.ConsoleApp.ProjectID)
+				assert.NotNil(t, got.ConsoleApp.APIConfig)
+			})
+		})
+	}
+}
+
+func TestInstanceSearchQueries_Validate(t *testing.T) {
+	mustQuery := func(query SearchQuery, err error) SearchQuery {
+		require.NoError(t, err)
+		return query
+	}
+	idsQuery := mustQuery(NewInstanceIDsListSearchQuery("id1", "id2"))
+	emptyIDsQuery := mustQuery(NewInstanceIDsListSearchQuery())
+	tests := []struct {
+		name    string
+		queries *InstanceSearchQueries
+		wantErr bool
+		cause   error
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "valid",
+			queries: &InstanceSearchQueries{
+				SearchRequest: SearchRequest{Limit: 10, Offset: 20},
+				Queries: []SearchQuery{
+					idsQuery,
+					mustQuery(NewInstanceDomainsListSearchQuery("example.com")),
+					mustQuery(NewOrQuery(idsQuery, mustQuery(NewInstanceIDPrefixSearchQuery("2345")))),
+				},
+			},
+		},
+		{
+			name: "empty in list",
+			queries: &InstanceSearchQueries{
+				Queries: []SearchQuery{idsQuery, emptyIDsQuery},
+			},
+			wantErr: true,
+			cause:   ErrEmptyValues,
+		},
+		{
+			name: "empty in list in or query",
+			queries: &InstanceSearchQueries{
+				Queries: []SearchQuery{mustQuery(NewOrQuery(idsQuery, emptyIDsQuery))},
+			},
+			wantErr: true,
+			cause:   ErrEmptyValues,
+		},
+		{
+			name: "empty in list in not query",
+			queries: &InstanceSearchQueries{
+				Queries: []SearchQuery{mustQuery(NewNotQuery(emptyIDsQuery))},
+			},
+			wantErr: true,
+			cause:   ErrEmptyValues,
+		},
+		{
+			name: "nil query",
+			queries: &InstanceSearchQueries{
+				Queries: []SearchQuery{nil},
+			},
+			wantErr: true,
+			cause:   ErrNothingSelected,
+		},
+		{
+			name: "limit out of range",
+			queries: &InstanceSearchQueries{
+				SearchRequest: SearchRequest{Limit: math.MaxUint64},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.queries.Validate()
+			if !tt.wantErr {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, zerrors.IsErrorInvalidArgument(err), "unexpected error: %v", err)
+			if tt.cause != nil {
+				require.ErrorIs(t, err, tt.cause)
+			}
+		})
+	}
+}
+
+func TestInstanceSearchQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    func() (SearchQuery, error)
+		wantSQL  string
+		wantArgs []interface{}
+		wantErr  func(error) bool
+	}{
+		{
+			name:     "only generated domain",
+			query:    NewInstanceOnlyGeneratedDomainSearchQuery,
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE NOT (projections.instances.id IN ( SELECT projections.instance_domains.instance_id FROM projections.instance_domains WHERE projections.instance_domains.is_generated = $1 ))",
+			wantArgs: []interface{}{false},
+		},
+		{
+			name: "audit retention below minimum",
+			query: func() (SearchQuery, error) {
+				return NewInstanceAuditRetentionSearchQuery(NumberLess, 30)
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id IN ( SELECT projections.limits.instance_id FROM projections.limits WHERE projections.limits.audit_log_retention < $1 )",
+			wantArgs: []interface{}{30 * 24 * time.Hour},
+		},
+		{
+			name: "audit retention invalid comparison",
+			query: func() (SearchQuery, error) {
+				return NewInstanceAuditRetentionSearchQuery(-1, 30)
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrInvalidCompare) },
+		},
+		{
+			name: "has custom login policy",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHasCustomLoginPolicySearchQuery(true)
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id IN ( SELECT projections.login_policies5.instance_id FROM projections.login_policies5 WHERE projections.login_policies5.is_default = $1 AND projections.login_policies5.owner_removed = $2 )",
+			wantArgs: []interface{}{false, false},
+		},
+		{
+			name: "default login policy only",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHasCustomLoginPolicySearchQuery(false)
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE NOT (projections.instances.id IN ( SELECT projections.login_policies5.instance_id FROM projections.login_policies5 WHERE projections.login_policies5.is_default = $1 AND projections.login_policies5.owner_removed = $2 ))",
+			wantArgs: []interface{}{false, false},
+		},
+		{
+			name: "column",
+			query: func() (SearchQuery, error) {
+				return NewInstanceColumnSearchQuery("name", TextStartsWithIgnoreCase, "acme")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.name ILIKE $1",
+			wantArgs: []interface{}{"acme%"},
+		},
+		{
+			name: "column not allowed",
+			query: func() (SearchQuery, error) {
+				return NewInstanceColumnSearchQuery("name; DROP TABLE projections.instances", TextEquals, "acme")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "column with invalid comparison",
+			query: func() (SearchQuery, error) {
+				return NewInstanceColumnSearchQuery("id", -1, "acme")
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrInvalidCompare) },
+		},
+		{
+			name: "has idp",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHasIDPSearchQuery("SAML")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id IN ( SELECT projections.idp_templates6.instance_id FROM projections.idp_templates6 WHERE projections.idp_templates6.type = $1 AND projections.idp_templates6.owner_removed = $2 )",
+			wantArgs: []interface{}{domain.IDPTypeSAML, false},
+		},
+		{
+			name: "has idp of unknown type",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHasIDPSearchQuery("kerberos")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "over quota",
+			query: func() (SearchQuery, error) {
+				return NewInstanceOverQuotaSearchQuery("requests.all.authenticated")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id IN ( SELECT projections.quotas_periods.instance_id FROM projections.quotas_periods JOIN projections.quotas ON projections.quotas_periods.unit = projections.quotas.unit AND projections.quotas_periods.instance_id = projections.quotas.instance_id WHERE (projections.quotas.limit_usage = $1 AND projections.quotas_periods.unit = $2 AND age(projections.quotas_periods.start) < projections.quotas.interval AND projections.quotas_periods.start <= now() AND projections.quotas_periods.start >= projections.quotas.from_anchor AND projections.quotas_periods.usage >= projections.quotas.amount) )",
+			wantArgs: []interface{}{true, quota.RequestsAllAuthenticated},
+		},
+		{
+			name: "over quota of unknown type",
+			query: func() (SearchQuery, error) {
+				return NewInstanceOverQuotaSearchQuery("users.max")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "admin email domain",
+			query: func() (SearchQuery, error) {
+				return NewInstanceAdminEmailDomainSearchQuery("@ACME.com")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id IN ( SELECT members.instance_id FROM projections.instance_members4 AS members WHERE members.roles @> $1  AND members.user_id IN ( SELECT projections.users13_humans.user_id FROM projections.users13_humans WHERE projections.users13_humans.email ILIKE $2 ) )",
+			wantArgs: []interface{}{[]interface{}{domain.RoleIAMOwner}, "%@acme.com"},
+		},
+		{
+			name: "admin email domain empty",
+			query: func() (SearchQuery, error) {
+				return NewInstanceAdminEmailDomainSearchQuery(" @ ")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "stale",
+			query: func() (SearchQuery, error) {
+				return NewInstanceStaleSearchQuery(dayNow)
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.change_date < $1",
+			wantArgs: []interface{}{dayNow},
+		},
+		{
+			name: "default language",
+			query: func() (SearchQuery, error) {
+				return NewInstanceDefaultLanguageSearchQuery("de-CH")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.default_language = $1",
+			wantArgs: []interface{}{"de-CH"},
+		},
+		{
+			name: "default language, invalid",
+			query: func() (SearchQuery, error) {
+				return NewInstanceDefaultLanguageSearchQuery("not a tag")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "host tld, com",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHostTLDSearchQuery("com")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE (projections.instance_domains.domain LIKE $1)",
+			wantArgs: []interface{}{"%.com"},
+		},
+		{
+			name: "host tld, com and io",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHostTLDSearchQuery(".COM", "io")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE (projections.instance_domains.domain LIKE $1 OR projections.instance_domains.domain LIKE $2)",
+			wantArgs: []interface{}{"%.com", "%.io"},
+		},
+		{
+			name: "host tld, multi-label",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHostTLDSearchQuery("co.uk")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE (projections.instance_domains.domain LIKE $1)",
+			wantArgs: []interface{}{"%.co.uk"},
+		},
+		{
+			name: "host tld, none",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHostTLDSearchQuery()
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "host tld, empty",
+			query: func() (SearchQuery, error) {
+				return NewInstanceHostTLDSearchQuery("com", ".")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name: "names",
+			query: func() (SearchQuery, error) {
+				return NewInstanceNamesListSearchQuery("ACME", "zitadel")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.name IN ($1,$2)",
+			wantArgs: []interface{}{"ACME", "zitadel"},
+		},
+		{
+			name: "names, ignore case",
+			query: func() (SearchQuery, error) {
+				return NewInstanceNamesListIgnoreCaseSearchQuery("ACME", "my_instance")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE (projections.instances.name ILIKE $1 OR projections.instances.name ILIKE $2)",
+			wantArgs: []interface{}{"ACME", `my\_instance`},
+		},
+		{
+			name: "names, ignore case, none",
+			query: func() (SearchQuery, error) {
+				return NewInstanceNamesListIgnoreCaseSearchQuery()
+			},
+			wantErr: func(err error) bool { return errors.Is(err, ErrMissingColumn) },
+		},
+		{
+			name: "id prefix",
+			query: func() (SearchQuery, error) {
+				return NewInstanceIDPrefixSearchQuery("2756")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id LIKE $1",
+			wantArgs: []interface{}{"2756%"},
+		},
+		{
+			name: "id prefix, wildcards escaped",
+			query: func() (SearchQuery, error) {
+				return NewInstanceIDPrefixSearchQuery("27_%")
+			},
+			wantSQL:  "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.id LIKE $1",
+			wantArgs: []interface{}{`27\_\%%`},
+		},
+		{
+			name: "id prefix, too short",
+			query: func() (SearchQuery, error) {
+				return NewInstanceIDPrefixSearchQuery("275")
+			},
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := tt.query()
+			if tt.wantErr != nil {
+				require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			stmt, args, err := query.toQuery(
+				sq.Select(InstanceColumnID.identifier()).From(instanceTable.identifier()).PlaceholderFormat(sq.Dollar),
+			).ToSql()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, stmt)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestInstanceDefaultLoginPolicySearchQueries(t *testing.T) {
+	const wantStmt = "SELECT projections.instances.id FROM projections.instances" +
+		" WHERE projections.instances.id IN ( SELECT projections.login_policies5.instance_id FROM projections.login_policies5" +
+		" WHERE projections.login_policies5.is_default = $1 AND projections.login_policies5.%s = $2 )"
+	tests := []struct {
+		name      string
+		query     func(bool) (SearchQuery, error)
+		column    string
+		wantTrue  interface{}
+		wantFalse interface{}
+	}{
+		{
+			name:      "mfa enforced",
+			query:     NewInstanceMFAEnforcedSearchQuery,
+			column:    "force_mfa",
+			wantTrue:  true,
+			wantFalse: false,
+		},
+		{
+			name:      "sso enabled",
+			query:     NewInstanceSSOEnabledSearchQuery,
+			column:    "allow_external_idps",
+			wantTrue:  true,
+			wantFalse: false,
+		},
+		{
+			name:      "open registration",
+			query:     NewInstanceOpenRegistrationSearchQuery,
+			column:    "allow_register",
+			wantTrue:  true,
+			wantFalse: false,
+		},
+		{
+			name:      "passwordless allowed",
+			query:     NewInstancePasswordlessAllowedSearchQuery,
+			column:    "passwordless_type",
+			wantTrue:  domain.PasswordlessTypeAllowed,
+			wantFalse: domain.PasswordlessTypeNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		for value, want := range map[bool]interface{}{true: tt.wantTrue, false: tt.wantFalse} {
+			t.Run(fmt.Sprintf("%s %t", tt.name, value), func(t *testing.T) {
+				query, err := tt.query(value)
+				require.NoError(t, err)
+				stmt, args, err := query.toQuery(
+					sq.Select(InstanceColumnID.identifier()).From(instanceTable.identifier()).PlaceholderFormat(sq.Dollar),
+				).ToSql()
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf(wantStmt, tt.column), stmt)
+				assert.Equal(t, []interface{}{true, want}, args)
+			})
+		}
+	}
+}
+
+func TestQueries_StreamInstances(t *testing.T) {
+	rows := [][]driver.Value{
+		instanceRow("id1", "one.zitadel.cloud"),
+		instanceRow("id1", "one.example.com"),
+		instanceRow("id2", "two.zitadel.cloud"),
+	}
+	wantDomains := map[string][]string{
+		"id1": {"one.zitadel.cloud", "one.example.com"},
+		"id2": {"two.zitadel.cloud"},
+	}
+	for _, allocate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allocate %t", allocate), func(t *testing.T) {
+			execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+
+				var (
+					pointers []*Instance
+					ids      []string
+				)
+				err := q.StreamInstances(context.Background(), &InstanceSearchQueries{}, allocate, func(instance *Instance) error {
+					pointers = append(pointers, instance)
+					ids = append(ids, instance.ID)
+					assert.Equal(t, "name-"+instance.ID, instance.Name)
+					assert.Equal(t, language.English, instance.DefaultLang)
+					domains := make([]string, len(instance.Domains))
+					for i, domain := range instance.Domains {
+						domains[i] = domain.Domain
+						assert.Equal(t, instance.ID, domain.InstanceID)
+					}
+					assert.Equal(t, wantDomains[instance.ID], domains)
+					return nil
+				})
+				require.NoError(t, err)
+				assert.Equal(t, []string{"id1", "id2"}, ids)
+				require.Len(t, pointers, 2)
+				if allocate {
+					assert.NotSame(t, pointers[0], pointers[1])
+				} else {
+					assert.Same(t, pointers[0], pointers[1])
+				}
+			})
+		})
+	}
+
+	t.Run("callback error", func(t *testing.T) {
+		execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			stop := errors.New("stop")
+			var calls int
+			err := q.StreamInstances(context.Background(), &InstanceSearchQueries{}, false, func(*Instance) error {
+				calls++
+				return stop
+			})
+			require.ErrorIs(t, err, stop)
+			assert.Equal(t, 1, calls)
+		})
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		emptyIDsQuery, err := NewInstanceIDsListSearchQuery()
+		require.NoError(t, err)
+		execMock(t, func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m }, func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			var calls int
+			err := q.StreamInstances(context.Background(), &InstanceSearchQueries{Queries: []SearchQuery{emptyIDsQuery}}, false, func(*Instance) error {
+				calls++
+				return nil
+			})
+			assert.True(t, zerrors.IsErrorInvalidArgument(err), "want invalid argument, got %v", err)
+			assert.Zero(t, calls)
+		})
+	})
+}
+
+func TestQueries_ExportInstancesNDJSON(t *testing.T) {
+	rows := [][]driver.Value{
+		instanceRow("id1", "one.zitadel.cloud"),
+		instanceRow("id1", "one.example.com"),
+		instanceRow("id2", "two.zitadel.cloud"),
+	}
+	execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+		q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+		var buf bytes.Buffer
+		count, err := q.ExportInstancesNDJSON(context.Background(), &InstanceSearchQueries{}, &buf)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), count)
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		require.Len(t, lines, 2)
+		want := []instanceNDJSON{
+			{ID: "id1", Name: "name-id1", CreationDate: testNow, ChangeDate: testNow, Sequence: 20211108, DefaultLanguage: "en", Domains: []string{"one.zitadel.cloud", "one.example.com"}},
+			{ID: "id2", Name: "name-id2", CreationDate: testNow, ChangeDate: testNow, Sequence: 20211108, DefaultLanguage: "en", Domains: []string{"two.zitadel.cloud"}},
+		}
+		for i, line := range lines {
+			require.True(t, json.Valid([]byte(line)), "invalid json: %s", line)
+			var got instanceNDJSON
+			require.NoError(t, json.Unmarshal([]byte(line), &got))
+			assert.True(t, want[i].CreationDate.Equal(got.CreationDate))
+			assert.True(t, want[i].ChangeDate.Equal(got.ChangeDate))
+			got.CreationDate, got.ChangeDate = want[i].CreationDate, want[i].ChangeDate
+			assert.Equal(t, want[i], got)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		execMock(t, func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m }, func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			var buf bytes.Buffer
+			count, err := q.ExportInstancesNDJSON(ctx, &InstanceSearchQueries{}, &buf)
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Zero(t, count)
+			assert.Empty(t, buf.String())
+		})
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		emptyIDsQuery, err := NewInstanceIDsListSearchQuery()
+		require.NoError(t, err)
+		execMock(t, func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m }, func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			var buf bytes.Buffer
+			count, err := q.ExportInstancesNDJSON(context.Background(), &InstanceSearchQueries{Queries: []SearchQuery{emptyIDsQuery}}, &buf)
+			assert.True(t, zerrors.IsErrorInvalidArgument(err), "want invalid argument, got %v", err)
+			assert.Zero(t, count)
+			assert.Empty(t, buf.String())
+		})
+	})
+}
+
+func BenchmarkQueries_StreamInstances(b *testing.B) {
+	rows := make([][]driver.Value, 100)
+	for i := range rows {
+		rows[i] = instanceRow(fmt.Sprintf("id%d", i), fmt.Sprintf("%d.zitadel.cloud", i))
+	}
+	for _, allocate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("allocate %t", allocate), func(b *testing.B) {
+			db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(new(db_mock.TypeConverter)))
+			require.NoError(b, err)
+			defer db.Close()
+			for range b.N {
+				mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows)(mock)
+			}
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				err = q.StreamInstances(context.Background(), &InstanceSearchQueries{}, allocate, func(*Instance) error { return nil })
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// instancesQueryWhere returns [instancesQuery] with where applied to the filter sub-select.
+func instancesQueryWhere(where string) string {
+	return instancesQueryFilter(" WHERE " + where)
+}
+
+// instancesQueryFilter returns [instancesQuery] with clauses appended to the filter sub-select.
+func instancesQueryFilter(clauses string) string {
+	return strings.Replace(instancesQuery, instancesFilterQuery, strings.TrimSuffix(instancesFilterQuery, ") AS f")+clauses+") AS f", 1)
+}
+
+// shuffledInstancesQuery returns [instancesQuery] shuffled by the seed $1, with clauses appended to the filter sub-select.
+func shuffledInstancesQuery(clauses string) string {
+	query := strings.Replace(instancesQueryFilter(clauses), "COUNT(*) OVER ()", "COUNT(*) OVER (), md5(projections.instances.id || $1) AS shuffle_key", 1)
+	return query + " ORDER BY f.shuffle_key"
+}
+
+func TestQueries_SearchInstances(t *testing.T) {
+	staleQuery, err := NewInstanceStaleSearchQuery(dayNow)
+	require.NoError(t, err)
+	prefixQuery, err := NewInstanceIDPrefixSearchQuery("2756")
+	require.NoError(t, err)
+	namesQuery, err := NewInstanceNamesListSearchQuery("name-id1", "missing", "name-id2")
+	require.NoError(t, err)
+	emptyIDsQuery, err := NewInstanceIDsListSearchQuery()
+	require.NoError(t, err)
+	shortRetentionQuery, err := NewInstanceAuditRetentionSearchQuery(NumberLess, 30)
+	require.NoError(t, err)
+	longRetentionQuery, err := NewInstanceAuditRetentionSearchQuery(NumberGreater, 29)
+	require.NoError(t, err)
+	retentionRow := func(id string, retention time.Duration) []driver.Value {
+		row := instanceRow(id, id+".zitadel.cloud")
+		row[11] = retention
+		return row
+	}
+	customLoginPolicyQuery, err := NewInstanceHasCustomLoginPolicySearchQuery(true)
+	require.NoError(t, err)
+	defaultLoginPolicyQuery, err := NewInstanceHasCustomLoginPolicySearchQuery(false)
+	require.NoError(t, err)
+	samlIDPQuery, err := NewInstanceHasIDPSearchQuery("saml")
+	require.NoError(t, err)
+	acmeAdminQuery, err := NewInstanceAdminEmailDomainSearchQuery("acme.com")
+	require.NoError(t, err)
+	overQuotaQuery, err := NewInstanceOverQuotaSearchQuery("actions.all.runs.seconds")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		maxResultSize uint64
+		queries       *InstanceSearchQueries
+		mock          sqlExpectation
+		want          []string
+		wantErr       error
+	}{
+		{
+			name:    "stale",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{staleQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.change_date < $1")),
+				instancesCols,
+				[][]driver.Value{instanceRow("id1", "one.zitadel.cloud")},
+				dayNow,
+			),
+			want: []string{"id1"},
+		},
+		{
+			name:    "id prefix, one match",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{prefixQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id LIKE $1")),
+				instancesCols,
+				[][]driver.Value{instanceRow("275671", "one.zitadel.cloud")},
+				"2756%",
+			),
+			want: []string{"275671"},
+		},
+		{
+			name:    "id prefix, several matches",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{prefixQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id LIKE $1")),
+				instancesCols,
+				[][]driver.Value{
+					instanceRow("275671", "one.zitadel.cloud"),
+					instanceRow("275682", "two.zitadel.cloud"),
+				},
+				"2756%",
+			),
+			want: []string{"275671", "275682"},
+		},
+		{
+			name:    "names, missing absent",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{namesQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.name IN ($1,$2,$3)")),
+				instancesCols,
+				[][]driver.Value{
+					instanceRow("id1", "one.zitadel.cloud"),
+					instanceRow("id2", "two.zitadel.cloud"),
+				},
+				"name-id1", "missing", "name-id2",
+			),
+			want: []string{"id1", "id2"},
+		},
+		{
+			name:    "shuffled",
+			queries: &InstanceSearchQueries{ShuffleSeed: "seed"},
+			mock: mockQueries(
+				regexp.QuoteMeta(shuffledInstancesQuery(" ORDER BY shuffle_key")),
+				instancesCols,
+				[][]driver.Value{
+					instanceRow("id2", "two.zitadel.cloud"),
+					instanceRow("id1", "one.zitadel.cloud"),
+					instanceRow("id1", "one.example.com"),
+				},
+				"seed",
+			),
+			want: []string{"id2", "id1"},
+		},
+		{
+			name:    "shuffled with filter",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{prefixQuery}, ShuffleSeed: "seed"},
+			mock: mockQueries(
+				regexp.QuoteMeta(shuffledInstancesQuery(" WHERE projections.instances.id LIKE $2 ORDER BY shuffle_key")),
+				instancesCols,
+				[][]driver.Value{instanceRow("275671", "one.zitadel.cloud")},
+				"seed", "2756%",
+			),
+			want: []string{"275671"},
+		},
+		{
+			name:          "max result size, no limit",
+			maxResultSize: 10,
+			queries:       &InstanceSearchQueries{},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryFilter(" LIMIT 10")),
+				instancesCols,
+				[][]driver.Value{instanceRow("id1", "one.zitadel.cloud")},
+			),
+			want: []string{"id1"},
+		},
+		{
+			name:          "max result size, within",
+			maxResultSize: 10,
+			queries:       &InstanceSearchQueries{SearchRequest: SearchRequest{Limit: 5}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryFilter(" LIMIT 5")),
+				instancesCols,
+				[][]driver.Value{instanceRow("id1", "one.zitadel.cloud")},
+			),
+			want: []string{"id1"},
+		},
+		{
+			name:          "max result size, at",
+			maxResultSize: 10,
+			queries:       &InstanceSearchQueries{SearchRequest: SearchRequest{Limit: 10}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryFilter(" LIMIT 10")),
+				instancesCols,
+				[][]driver.Value{instanceRow("id1", "one.zitadel.cloud")},
+			),
+			want: []string{"id1"},
+		},
+		{
+			name:          "max result size, exceeded",
+			maxResultSize: 10,
+			queries:       &InstanceSearchQueries{SearchRequest: SearchRequest{Limit: 11}},
+			mock:          func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr:       ErrInstanceResultSizeExceeded,
+		},
+		{
+			name:    "audit retention below minimum",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{shortRetentionQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.limits.instance_id FROM projections.limits WHERE projections.limits.audit_log_retention < $1 )")),
+				instancesCols,
+				[][]driver.Value{retentionRow("short", 7*24*time.Hour)},
+				30*24*time.Hour,
+			),
+			want: []string{"short"},
+		},
+		{
+			name:    "audit retention compliant",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{longRetentionQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.limits.instance_id FROM projections.limits WHERE projections.limits.audit_log_retention > $1 )")),
+				instancesCols,
+				[][]driver.Value{retentionRow("compliant", 365*24*time.Hour)},
+				29*24*time.Hour,
+			),
+			want: []string{"compliant"},
+		},
+		{
+			name:    "custom login policy",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{customLoginPolicyQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.login_policies5.instance_id FROM projections.login_policies5 WHERE projections.login_policies5.is_default = $1 AND projections.login_policies5.owner_removed = $2 )")),
+				instancesCols,
+				[][]driver.Value{instanceRow("custom", "custom.zitadel.cloud")},
+				false, false,
+			),
+			want: []string{"custom"},
+		},
+		{
+			name:    "default login policy",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{defaultLoginPolicyQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("NOT (projections.instances.id IN ( SELECT projections.login_policies5.instance_id FROM projections.login_policies5 WHERE projections.login_policies5.is_default = $1 AND projections.login_policies5.owner_removed = $2 ))")),
+				instancesCols,
+				[][]driver.Value{instanceRow("default", "default.zitadel.cloud")},
+				false, false,
+			),
+			want: []string{"default"},
+		},
+		{
+			name:    "has saml idp",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{samlIDPQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.idp_templates6.instance_id FROM projections.idp_templates6 WHERE projections.idp_templates6.type = $1 AND projections.idp_templates6.owner_removed = $2 )")),
+				instancesCols,
+				[][]driver.Value{
+					instanceRow("saml-only", "saml-only.zitadel.cloud"),
+					instanceRow("saml-and-oidc", "saml-and-oidc.zitadel.cloud"),
+				},
+				domain.IDPTypeSAML, false,
+			),
+			want: []string{"saml-only", "saml-and-oidc"},
+		},
+		{
+			name:    "without saml idp",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{samlIDPQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.idp_templates6.instance_id FROM projections.idp_templates6 WHERE projections.idp_templates6.type = $1 AND projections.idp_templates6.owner_removed = $2 )")),
+				instancesCols,
+				nil,
+				domain.IDPTypeSAML, false,
+			),
+			want: []string{},
+		},
+		{
+			// the usage of "over" exceeds its quota, the usage of "at-limit" equals it,
+			// instances under their quota are not returned by the database
+			name:    "over quota",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{overQuotaQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT projections.quotas_periods.instance_id FROM projections.quotas_periods JOIN projections.quotas ON projections.quotas_periods.unit = projections.quotas.unit AND projections.quotas_periods.instance_id = projections.quotas.instance_id WHERE (projections.quotas.limit_usage = $1 AND projections.quotas_periods.unit = $2 AND age(projections.quotas_periods.start) < projections.quotas.interval AND projections.quotas_periods.start <= now() AND projections.quotas_periods.start >= projections.quotas.from_anchor AND projections.quotas_periods.usage >= projections.quotas.amount) )")),
+				instancesCols,
+				[][]driver.Value{
+					instanceRow("over", "over.zitadel.cloud"),
+					instanceRow("at-limit", "at-limit.zitadel.cloud"),
+				},
+				true, quota.ActionsAllRunsSeconds,
+			),
+			want: []string{"over", "at-limit"},
+		},
+		{
+			name:    "admin email domain, matching",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{acmeAdminQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT members.instance_id FROM projections.instance_members4 AS members WHERE members.roles @> $1  AND members.user_id IN ( SELECT projections.users13_humans.user_id FROM projections.users13_humans WHERE projections.users13_humans.email ILIKE $2 ) )")),
+				instancesCols,
+				[][]driver.Value{instanceRow("acme", "acme.zitadel.cloud")},
+				[]interface{}{domain.RoleIAMOwner}, "%@acme.com",
+			),
+			want: []string{"acme"},
+		},
+		{
+			name:    "admin email domain, not matching",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{acmeAdminQuery}},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere("projections.instances.id IN ( SELECT members.instance_id FROM projections.instance_members4 AS members WHERE members.roles @> $1  AND members.user_id IN ( SELECT projections.users13_humans.user_id FROM projections.users13_humans WHERE projections.users13_humans.email ILIKE $2 ) )")),
+				instancesCols,
+				nil,
+				[]interface{}{domain.RoleIAMOwner}, "%@acme.com",
+			),
+			want: []string{},
+		},
+		{
+			name:    "empty ids list",
+			queries: &InstanceSearchQueries{Queries: []SearchQuery{emptyIDsQuery}},
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: ErrEmptyValues,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{
+					client:                &database.DB{DB: db, Database: new(prepareDB)},
+					maxInstanceResultSize: tt.maxResultSize,
+				}
+				got, err := q.SearchInstances(context.Background(), tt.queries)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					assert.True(t, zerrors.IsErrorInvalidArgument(err))
+					return
+				}
+				require.NoError(t, err)
+				ids := make([]string, len(got.Instances))
+				for i, instance := range got.Instances {
+					ids[i] = instance.ID
+				}
+				assert.Equal(t, tt.want, ids)
+			})
+		})
+	}
+}
+
+func TestInstances_WriteCSV(t *testing.T) {
+	instances := &Instances{
+		Instances: []*Instance{
+			{ID: "id1", Name: "instance", CreationDate: dayNow, DefaultLang: language.English},
+			{ID: "id2", Name: `ACME, "Inc."`, CreationDate: dayNow.Add(time.Hour), DefaultLang: language.MustParse("de-CH")},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, instances.WriteCSV(&buf))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"id", "name", "creation_date", "default_language"},
+		{"id1", "instance", dayNow.Format(time.RFC3339), "en"},
+		{"id2", `ACME, "Inc."`, dayNow.Add(time.Hour).Format(time.RFC3339), "de-CH"},
+	}, records)
+}
+
+func TestInstance_LogValue(t *testing.T) {
+	instance := &Instance{
+		ID:           "instanceID",
+		Name:         "instance name",
+		DefaultOrgID: "orgID",
+		IAMProjectID: "projectID",
+		ConsoleID:    "consoleClientID",
+		ConsoleAppID: "consoleAppID",
+		DefaultLang:  language.German,
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("instance", "instance", instance)
+
+	assert.Contains(t, buf.String(), `"instance":{"id":"instanceID","name":"instance name","default_language":"de"}`)
+	assert.NotContains(t, buf.String(), "consoleAppID")
+	assert.NotContains(t, buf.String(), "consoleClientID")
+	assert.NotContains(t, buf.String(), "projectID")
+}
+
+func TestInstance_Age(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		creationDate time.Time
+		want         time.Duration
+		wantFormat   string
+	}{
+		{
+			name:         "normal age",
+			creationDate: now.Add(-(2*365 + 3*30 + 4) * 24 * time.Hour),
+			want:         (2*365 + 3*30 + 4) * 24 * time.Hour,
+			wantFormat:   "2y 3m",
+		},
+		{
+			name:         "months",
+			creationDate: now.Add(-(45*24 + 5) * time.Hour),
+			want:         (45*24 + 5) * time.Hour,
+			wantFormat:   "1m 15d",
+		},
+		{
+			name:       "unset creation date",
+			wantFormat: "0d",
+		},
+		{
+			name:         "future creation date",
+			creationDate: now.Add(time.Minute),
+			wantFormat:   "0d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			age := (&Instance{CreationDate: tt.creationDate}).Age(now)
+			assert.Equal(t, tt.want, age)
+			assert.Equal(t, tt.wantFormat, FormatInstanceAge(age))
+		})
+	}
+}
+
+func TestInstance_Diff(t *testing.T) {
+	newInstance := func() *Instance {
+		return &Instance{
+			ID:           "id",
+			ChangeDate:   testNow,
+			CreationDate: testNow,
+			Sequence:     1,
+			Name:         "name",
+			DefaultOrgID: "org-id",
+			IAMProjectID: "project-id",
+			ConsoleID:    "client-id",
+			ConsoleAppID: "app-id",
+			DefaultLang:  language.English,
+			Domains: []*InstanceDomain{
+				{Domain: "one.zitadel.cloud", InstanceID: "id", IsGenerated: true, CreationDate: testNow, ChangeDate: testNow},
+				{Domain: "one.example.com", InstanceID: "id", IsPrimary: true, CreationDate: testNow, ChangeDate: testNow},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		change func(*Instance)
+		want   []string
+	}{
+		{
+			name:   "identical",
+			change: func(*Instance) {},
+			want:   nil,
+		},
+		{
+			name: "domains reordered",
+			change: func(i *Instance) {
+				i.Domains[0], i.Domains[1] = i.Domains[1], i.Domains[0]
+			},
+			want: nil,
+		},
+		{
+			name: "name",
+			change: func(i *Instance) {
+				i.Name = "new name"
+			},
+			want: []string{"Name"},
+		},
+		{
+			name: "multiple fields",
+			change: func(i *Instance) {
+				i.ChangeDate = testNow.Add(time.Second)
+				i.Sequence = 2
+				i.DefaultLang = language.German
+				i.Domains[1].IsPrimary = false
+			},
+			want: []string{"ChangeDate", "Sequence", "DefaultLang", "Domains"},
+		},
+		{
+			name: "domain added",
+			change: func(i *Instance) {
+				i.Domains = append(i.Domains, &InstanceDomain{Domain: "two.example.com", InstanceID: "id"})
+			},
+			want: []string{"Domains"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := newInstance()
+			tt.change(other)
+			assert.Equal(t, tt.want, newInstance().Diff(other))
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		assert.Empty(t, (*Instance)(nil).Diff(new(Instance)))
+		assert.Contains(t, newInstance().Diff(nil), "ID")
+	})
+}
+
+var (
+	instanceQuery = `SELECT projections.instances.id,` +
+		` projections.instances.creation_date,` +
+		` projections.instances.change_date,` +
+		` projections.instances.sequence,` +
+		` projections.instances.name,` +
+		` projections.instances.default_org_id,` +
+		` projections.instances.iam_project_id,` +
+		` projections.instances.console_client_id,` +
+		` projections.instances.console_app_id,` +
+		` projections.instances.default_language,` +
+		` projections.limits.audit_log_retention,` +
+		` projections.instance_domains.domain,` +
+		` projections.instance_domains.is_primary,` +
+		` projections.instance_domains.is_generated,` +
+		` projections.instance_domains.creation_date,` +
+		` projections.instance_domains.change_date,` +
+		` projections.instance_domains.sequence` +
+		` FROM projections.instances` +
+		` LEFT JOIN projections.limits ON projections.instances.id = projections.limits.instance_id` +
+		` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id` +
+		` AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE projections.instances.id = $1`
+	instanceCols = instancesCols[1:]
+)
+
+func TestQueries_Instance_context(t *testing.T) {
+	row := instanceRow("instanceID", "test.zitadel.cloud")[1:]
+
+	t.Run("reused within instance context", func(t *testing.T) {
+		execMock(t, mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{row}, "instanceID"), func(db *sql.DB) {
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+			ctx := NewInstanceContext(authz.WithInstanceID(context.Background(), "instanceID"))
+			_, ok := InstanceFromContext(ctx)
+			assert.False(t, ok)
+
+			first, err := q.Instance(ctx, false)
+			require.NoError(t, err)
+			fromCtx, ok := InstanceFromContext(ctx)
+			require.True(t, ok)
+			assert.Same(t, first, fromCtx)
+
+			second, err := q.Instance(ctx, false)
+			require.NoError(t, err)
+			assert.Same(t, first, second)
+		})
+	})
+
+	t.Run("without instance context", func(t *testing.T) {
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{row}, "instanceID")(m)
+				return mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{row}, "instanceID")(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx := authz.WithInstanceID(context.Background(), "instanceID")
+				_, err := q.Instance(ctx, false)
+				require.NoError(t, err)
+				_, err = q.Instance(ctx, false)
+				require.NoError(t, err)
+				_, ok := InstanceFromContext(ctx)
+				assert.False(t, ok)
+			},
+		)
+	})
+}
+
+func TestValidLanguageTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		lang    string
+		want    language.Tag
+		wantErr bool
+	}{
+		{
+			name: "language",
+			lang: "en",
+			want: language.English,
+		},
+		{
+			name: "language and region",
+			lang: "de-CH",
+			want: language.MustParse("de-CH"),
+		},
+		{
+			name:    "well-formed but unknown",
+			lang:    "xx",
+			want:    language.Und,
+			wantErr: true,
+		},
+		{
+			name:    "undefined",
+			lang:    "und",
+			want:    language.Und,
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			lang:    "not a tag",
+			want:    language.Und,
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			lang:    "",
+			want:    language.Und,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidLanguageTag(tt.lang)
+			if tt.wantErr {
+				assert.True(t, zerrors.IsErrorInvalidArgument(err), "want invalid argument, got: %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQueries_InstanceStorageEstimate(t *testing.T) {
+	stmt := regexp.QuoteMeta(`SELECT` +
+		` (SELECT COUNT(*) FROM projections.users13 WHERE projections.users13.instance_id = $1) AS users,` +
+		` (SELECT COUNT(*) FROM projections.sessions8 WHERE projections.sessions8.instance_id = $2) AS sessions,` +
+		` (SELECT COALESCE(SUM(projections.instances.sequence), 0) FROM projections.instances WHERE projections.instances.id = $3) AS instance_events,` +
+		` (SELECT COALESCE(SUM(projections.orgs1.sequence), 0) FROM projections.orgs1 WHERE projections.orgs1.instance_id = $4) AS org_events,` +
+		` (SELECT COALESCE(SUM(projections.users13.sequence), 0) FROM projections.users13 WHERE projections.users13.instance_id = $5) AS user_events,` +
+		` (SELECT COALESCE(SUM(projections.sessions8.sequence), 0) FROM projections.sessions8 WHERE projections.sessions8.instance_id = $6) AS session_events`)
+	cols := []string{"users", "sessions", "instance_events", "org_events", "user_events", "session_events"}
+	instanceArgs := []driver.Value{"instanceID", "instanceID", "instanceID", "instanceID", "instanceID", "instanceID"}
+	tests := []struct {
+		name       string
+		instanceID string
+		mock       sqlExpectation
+		want       *StorageEstimate
+		wantErr    func(error) bool
+	}{
+		{
+			name:       "empty instance",
+			instanceID: "instanceID",
+			mock:       mockQuery(stmt, cols, []driver.Value{uint64(0), uint64(0), uint64(0), uint64(0), uint64(0), uint64(0)}, instanceArgs...),
+			want:       &StorageEstimate{},
+		},
+		{
+			name:       "small instance",
+			instanceID: "instanceID",
+			mock:       mockQuery(stmt, cols, []driver.Value{uint64(10), uint64(5), uint64(20), uint64(10), uint64(50), uint64(20)}, instanceArgs...),
+			want: &StorageEstimate{
+				Events:   100,
+				Users:    10,
+				Sessions: 5,
+				Bytes:    100*estimatedEventBytes + 10*estimatedUserBytes + 5*estimatedSessionBytes,
+			},
+		},
+		{
+			name:       "ten times the rows",
+			instanceID: "instanceID",
+			mock:       mockQuery(stmt, cols, []driver.Value{uint64(100), uint64(50), uint64(200), uint64(100), uint64(500), uint64(200)}, instanceArgs...),
+			want: &StorageEstimate{
+				Events:   1000,
+				Users:    100,
+				Sessions: 50,
+				Bytes:    10 * (100*estimatedEventBytes + 10*estimatedUserBytes + 5*estimatedSessionBytes),
+			},
+		},
+		{
+			name:    "missing instance id",
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:       "sql error",
+			instanceID: "instanceID",
+			mock:       mockQueryErr(stmt, sql.ErrConnDone, instanceArgs...),
+			wantErr:    zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceStorageEstimate(context.Background(), tt.instanceID)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceLanguageReport(t *testing.T) {
+	stmt := regexp.QuoteMeta(`SELECT l.default_language, l.name, l.count FROM (` +
+		`SELECT projections.instances.default_language, projections.instances.name,` +
+		` COUNT(*) OVER (PARTITION BY projections.instances.default_language) AS count,` +
+		` ROW_NUMBER() OVER (PARTITION BY projections.instances.default_language ORDER BY projections.instances.name, projections.instances.id) AS sample` +
+		` FROM projections.instances) AS l` +
+		` WHERE l.sample <= $1 ORDER BY l.default_language, l.sample`)
+	cols := []string{"default_language", "name", "amount"}
+	tests := []struct {
+		name    string
+		samples int
+		mock    sqlExpectation
+		want    map[language.Tag]LanguageReportEntry
+		wantErr func(error) bool
+	}{
+		{
+			name:    "counts and samples",
+			samples: 2,
+			mock: mockQueries(stmt, cols, [][]driver.Value{
+				{"de", "acme", uint64(3)},
+				{"de", "beta", uint64(3)},
+				{"en", "gamma", uint64(1)},
+				{nil, "delta", uint64(1)},
+			}, 2),
+			want: map[language.Tag]LanguageReportEntry{
+				language.German:  {Count: 3, SampleNames: []string{"acme", "beta"}},
+				language.English: {Count: 1, SampleNames: []string{"gamma"}},
+				language.Und:     {Count: 1, SampleNames: []string{"delta"}},
+			},
+		},
+		{
+			name:    "samples limited",
+			samples: 1,
+			mock: mockQueries(stmt, cols, [][]driver.Value{
+				{"de", "acme", uint64(3)},
+				{"de", "beta", uint64(3)},
+			}, 1),
+			want: map[language.Tag]LanguageReportEntry{
+				language.German: {Count: 3, SampleNames: []string{"acme"}},
+			},
+		},
+		{
+			name:    "counts only",
+			samples: 0,
+			mock: mockQueries(stmt, cols, [][]driver.Value{
+				{"de", "acme", uint64(3)},
+				{"en", "gamma", uint64(1)},
+			}, 1),
+			want: map[language.Tag]LanguageReportEntry{
+				language.German:  {Count: 3},
+				language.English: {Count: 1},
+			},
+		},
+		{
+			name:    "negative samples",
+			samples: -1,
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceLanguageReport(context.Background(), tt.samples)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceCreationHistogram(t *testing.T) {
+	histogramStmt := func(field string) string {
+		return regexp.QuoteMeta(`SELECT date_trunc('` + field + `', projections.instances.creation_date AT TIME ZONE 'UTC') AS bucket, COUNT(*)` +
+			` FROM projections.instances AS OF SYSTEM TIME '-1 ms' GROUP BY bucket`)
+	}
+	cols := []string{"bucket", "amount"}
+	tests := []struct {
+		name    string
+		bucket  BucketSize
+		mock    sqlExpectation
+		want    map[time.Time]uint64
+		wantErr func(error) bool
+	}{
+		{
+			name:   "daily",
+			bucket: BucketSizeDay,
+			mock: mockQueries(histogramStmt("day"), cols, [][]driver.Value{
+				{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), uint64(2)},
+				{time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), uint64(1)},
+			}),
+			want: map[time.Time]uint64{
+				time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC): 2,
+				time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC): 1,
+			},
+		},
+		{
+			name:   "monthly",
+			bucket: BucketSizeMonth,
+			mock: mockQueries(histogramStmt("month"), cols, [][]driver.Value{
+				{time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("local", 3600)), uint64(5)},
+				{time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), uint64(3)},
+			}),
+			want: map[time.Time]uint64{
+				time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC): 5,
+				time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC): 3,
+			},
+		},
+		{
+			name:   "weekly",
+			bucket: BucketSizeWeek,
+			mock:   mockQueries(histogramStmt("week"), cols, nil),
+			want:   map[time.Time]uint64{},
+		},
+		{
+			name:    "invalid bucket",
+			bucket:  BucketSize(-1),
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceCreationHistogram(context.Background(), tt.bucket)
+				if tt.wantErr != nil {
+					assert.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestInstanceSearchQueries_nestedCombinators(t *testing.T) {
+	createdAfter, err := NewTimestampQuery(InstanceColumnCreationDate, dayNow, TimestampGreater)
+	require.NoError(t, err)
+	name, err := NewTextQuery(InstanceColumnName, "acme", TextContainsIgnoreCase)
+	require.NoError(t, err)
+	domain, err := NewTextQuery(InstanceDomainDomainCol, "acme", TextContainsIgnoreCase)
+	require.NoError(t, err)
+	generated, err := NewInstanceDomainGeneratedSearchQuery(true)
+	require.NoError(t, err)
+	notGenerated, err := NewNotQuery(generated)
+	require.NoError(t, err)
+
+	nameCreated, err := NewAndQuery(name, createdAfter)
+	require.NoError(t, err)
+	domainCreated, err := NewAndQuery(domain, createdAfter, notGenerated)
+	require.NoError(t, err)
+	either, err := NewOrQuery(nameCreated, domainCreated)
+	require.NoError(t, err)
+	stale, err := NewInstanceStaleSearchQuery(dayNow)
+	require.NoError(t, err)
+
+	filter, _, _ := prepareInstancesQuery(context.Background(), new(prepareDB))
+	stmt, args, err := (&InstanceSearchQueries{Queries: []SearchQuery{either, stale}}).
+		toQuery(filter).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances"+
+		" LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id"+
+		" WHERE ((projections.instances.name ILIKE $1 AND projections.instances.creation_date > $2)"+
+		" OR (projections.instance_domains.domain ILIKE $3 AND projections.instances.creation_date > $4 AND NOT (projections.instance_domains.is_generated = $5)))"+
+		" AND projections.instances.change_date < $6",
+		stmt,
+	)
+	assert.Equal(t, []interface{}{"%acme%", dayNow, "%acme%", dayNow, true, dayNow}, args)
+}
+
+func TestFilterNode_SearchQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		tree     string
+		wantSQL  string
+		wantArgs []interface{}
+		wantErr  func(error) bool
+	}{
+		{
+			name: "nested tree",
+			tree: `{"or": [
+				{"and": [
+					{"leaf": {"column": "name", "operator": "contains_ignore_case", "value": "acme"}},
+					{"leaf": {"column": "default_language", "operator": "equals", "value": "de"}}
+				]},
+				{"leaf": {"column": "domain", "operator": "ends_with", "value": ".acme.ch"}}
+			]}`,
+			wantSQL: "SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances" +
+				" LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id" +
+				" WHERE ((projections.instances.name ILIKE $1 AND projections.instances.default_language = $2)" +
+				" OR projections.instance_domains.domain LIKE $3)",
+			wantArgs: []interface{}{"%acme%", "de", "%.acme.ch"},
+		},
+		{
+			name:    "unknown column",
+			tree:    `{"leaf": {"column": "secret", "operator": "equals", "value": "x"}}`,
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:    "unknown operator",
+			tree:    `{"and": [{"leaf": {"column": "name", "operator": "matches", "value": "x"}}]}`,
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:    "several variants",
+			tree:    `{"and": [{"leaf": {"column": "id", "operator": "equals", "value": "x"}}], "leaf": {"column": "id", "operator": "equals", "value": "y"}}`,
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:    "empty or",
+			tree:    `{"or": []}`,
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:    "too deep",
+			tree:    strings.Repeat(`{"and": [`, maxFilterNodeDepth) + `{"leaf": {"column": "id", "operator": "equals", "value": "x"}}` + strings.Repeat(`]}`, maxFilterNodeDepth),
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := new(FilterNode)
+			require.NoError(t, json.Unmarshal([]byte(tt.tree), node))
+			query, err := node.SearchQuery()
+			if tt.wantErr != nil {
+				require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+
+			filter, _, _ := prepareInstancesQuery(context.Background(), new(prepareDB))
+			stmt, args, err := (&InstanceSearchQueries{Queries: []SearchQuery{query}}).
+				toQuery(filter).
+				PlaceholderFormat(sq.Dollar).
+				ToSql()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSQL, stmt)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestQueries_InstanceNameTaken(t *testing.T) {
+	const stmt = `SELECT EXISTS ( SELECT 1 FROM projections.instances AS OF SYSTEM TIME '-1 ms' WHERE LOWER(projections.instances.name) = LOWER($1) )`
+	tests := []struct {
+		name    string
+		input   string
+		mock    sqlExpectation
+		want    bool
+		wantErr func(error) bool
+	}{
+		{
+			name:  "exact case collision",
+			input: "acme",
+			mock:  mockQuery(regexp.QuoteMeta(stmt), []string{"exists"}, []driver.Value{true}, "acme"),
+			want:  true,
+		},
+		{
+			name:  "different case collision",
+			input: "Acme",
+			mock:  mockQuery(regexp.QuoteMeta(stmt), []string{"exists"}, []driver.Value{true}, "Acme"),
+			want:  true,
+		},
+		{
+			name:  "no collision",
+			input: "umbrella",
+			mock:  mockQuery(regexp.QuoteMeta(stmt), []string{"exists"}, []driver.Value{false}, "umbrella"),
+		},
+		{
+			name:    "empty name",
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+		{
+			name:    "sql error",
+			input:   "acme",
+			mock:    mockQueryErr(regexp.QuoteMeta(stmt), sql.ErrConnDone, "acme"),
+			wantErr: zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceNameTaken(context.Background(), tt.input)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestInstanceQueriesConfig_Options(t *testing.T) {
+	apply := func(config *InstanceQueriesConfig) *Queries {
+		q := new(Queries)
+		for _, opt := range config.Options() {
+			opt(q)
+		}
+		return q
+	}
+
+	assert.Empty(t, (*InstanceQueriesConfig)(nil).Options())
+
+	q := apply(&InstanceQueriesConfig{CacheRefreshAhead: time.Minute, MaxResultSize: 100})
+	assert.Equal(t, time.Minute, q.instanceCacheRefreshAhead)
+	assert.Equal(t, uint64(100), q.maxInstanceResultSize)
+	assert.Equal(t, " Acme ", q.normalizeInstanceName(" Acme "))
+
+	q = apply(&InstanceQueriesConfig{NormalizeNames: true})
+	assert.Equal(t, "acme", q.normalizeInstanceName(" Acme "))
+}
+
+func TestWithInstanceNameNormalizer(t *testing.T) {
+	normalize := func(name string) string {
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+	tests := []struct {
+		name      string
+		opts      []QueryOption
+		input     string
+		wantQuery string
+	}{
+		{
+			name:      "default",
+			input:     " Acme ",
+			wantQuery: " Acme ",
+		},
+		{
+			name:      "custom normalizer",
+			opts:      []QueryOption{WithInstanceNameNormalizer(normalize)},
+			input:     " Acme ",
+			wantQuery: "acme",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("name lookup", func(t *testing.T) {
+				q := new(Queries)
+				for _, opt := range tt.opts {
+					opt(q)
+				}
+				query, err := q.InstanceNamesSearchQuery(tt.input)
+				require.NoError(t, err)
+				stmt, args, err := query.toQuery(
+					sq.Select(InstanceColumnID.identifier()).From(instanceTable.identifier()).PlaceholderFormat(sq.Dollar),
+				).ToSql()
+				require.NoError(t, err)
+				assert.Equal(t, "SELECT projections.instances.id FROM projections.instances WHERE projections.instances.name IN ($1)", stmt)
+				assert.Equal(t, []interface{}{tt.wantQuery}, args)
+			})
+			t.Run("taken check", func(t *testing.T) {
+				const stmt = `SELECT EXISTS ( SELECT 1 FROM projections.instances AS OF SYSTEM TIME '-1 ms' WHERE LOWER(projections.instances.name) = LOWER($1) )`
+				execMock(t, mockQuery(regexp.QuoteMeta(stmt), []string{"exists"}, []driver.Value{true}, tt.wantQuery), func(db *sql.DB) {
+					q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+					for _, opt := range tt.opts {
+						opt(q)
+					}
+					taken, err := q.InstanceNameTaken(context.Background(), tt.input)
+					require.NoError(t, err)
+					assert.True(t, taken)
+				})
+			})
+		})
+	}
+
+	t.Run("normalized to empty", func(t *testing.T) {
+		q := new(Queries)
+		WithInstanceNameNormalizer(normalize)(q)
+		_, err := q.InstanceNameTaken(context.Background(), "  ")
+		require.True(t, zerrors.IsErrorInvalidArgument(err), "unexpected error: %v", err)
+	})
+}
+
+func TestQueries_InstanceUserCounts(t *testing.T) {
+	const userCountsStmt = `SELECT projections.instances.id, COUNT(projections.users13.id) FROM projections.instances` +
+		` LEFT JOIN projections.users13 ON (projections.users13.instance_id = projections.instances.id AND projections.users13.state NOT IN ($1,$2)) AS OF SYSTEM TIME '-1 ms'`
+	cols := []string{"id", "amount"}
+	tests := []struct {
+		name        string
+		instanceIDs []string
+		mock        sqlExpectation
+		want        map[string]uint64
+	}{
+		{
+			name: "all instances",
+			mock: mockQueries(regexp.QuoteMeta(userCountsStmt+` GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					{"id1", uint64(3)},
+					{"id2", uint64(0)},
+					{"id3", uint64(120)},
+				},
+				domain.UserStateInactive, domain.UserStateDeleted,
+			),
+			want: map[string]uint64{"id1": 3, "id2": 0, "id3": 120},
+		},
+		{
+			name:        "selected instances",
+			instanceIDs: []string{"id1", "id2"},
+			mock: mockQueries(regexp.QuoteMeta(userCountsStmt+` WHERE projections.instances.id IN ($3,$4) GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					{"id1", uint64(3)},
+					{"id2", uint64(0)},
+				},
+				domain.UserStateInactive, domain.UserStateDeleted, "id1", "id2",
+			),
+			want: map[string]uint64{"id1": 3, "id2": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceUserCounts(context.Background(), tt.instanceIDs...)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceSessionCounts(t *testing.T) {
+	const sessionCountsStmt = `SELECT projections.instances.id, COUNT(projections.sessions8.id) FROM projections.instances` +
+		` LEFT JOIN projections.sessions8 ON (projections.sessions8.instance_id = projections.instances.id AND projections.sessions8.state = $1) AS OF SYSTEM TIME '-1 ms'`
+	cols := []string{"id", "amount"}
+	tests := []struct {
+		name        string
+		instanceIDs []string
+		mock        sqlExpectation
+		want        map[string]uint64
+	}{
+		{
+			name: "all instances",
+			mock: mockQueries(regexp.QuoteMeta(sessionCountsStmt+` GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					{"id1", uint64(2)},
+					// only terminated sessions
+					{"id2", uint64(0)},
+				},
+				domain.SessionStateActive,
+			),
+			want: map[string]uint64{"id1": 2, "id2": 0},
+		},
+		{
+			name:        "selected instances",
+			instanceIDs: []string{"id1"},
+			mock: mockQueries(regexp.QuoteMeta(sessionCountsStmt+` WHERE projections.instances.id IN ($2) GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					{"id1", uint64(2)},
+				},
+				domain.SessionStateActive, "id1",
+			),
+			want: map[string]uint64{"id1": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceSessionCounts(context.Background(), tt.instanceIDs...)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceIDPCounts(t *testing.T) {
+	const idpCountsStmt = `SELECT projections.instances.id, COUNT(projections.idp_templates6.id) FROM projections.instances` +
+		` LEFT JOIN projections.idp_templates6 ON (projections.idp_templates6.instance_id = projections.instances.id AND projections.idp_templates6.owner_removed = $1 AND projections.idp_templates6.state = $2) AS OF SYSTEM TIME '-1 ms'`
+	cols := []string{"id", "amount"}
+	tests := []struct {
+		name        string
+		instanceIDs []string
+		mock        sqlExpectation
+		want        map[string]uint64
+	}{
+		{
+			name: "all instances",
+			mock: mockQueries(regexp.QuoteMeta(idpCountsStmt+` GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					// without idps
+					{"id1", uint64(0)},
+					{"id2", uint64(1)},
+					{"id3", uint64(3)},
+				},
+				false, domain.IDPStateActive,
+			),
+			want: map[string]uint64{"id1": 0, "id2": 1, "id3": 3},
+		},
+		{
+			name:        "selected instances",
+			instanceIDs: []string{"id2", "id3"},
+			mock: mockQueries(regexp.QuoteMeta(idpCountsStmt+` WHERE projections.instances.id IN ($3,$4) GROUP BY projections.instances.id`), cols,
+				[][]driver.Value{
+					{"id2", uint64(1)},
+					{"id3", uint64(3)},
+				},
+				false, domain.IDPStateActive, "id2", "id3",
+			),
+			want: map[string]uint64{"id2": 1, "id3": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceIDPCounts(context.Background(), tt.instanceIDs...)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_DefaultLanguages(t *testing.T) {
+	const defaultLanguagesStmt = `SELECT projections.instances.id, projections.instances.default_language FROM projections.instances AS OF SYSTEM TIME '-1 ms'`
+	cols := []string{"id", "default_language"}
+	tests := []struct {
+		name        string
+		instanceIDs []string
+		mock        sqlExpectation
+		want        map[string]language.Tag
+	}{
+		{
+			name: "all instances",
+			mock: mockQueries(regexp.QuoteMeta(defaultLanguagesStmt), cols,
+				[][]driver.Value{
+					{"id1", "de"},
+					{"id2", "en-US"},
+					{"id3", ""},
+					{"id4", nil},
+				},
+			),
+			want: map[string]language.Tag{
+				"id1": language.German,
+				"id2": language.AmericanEnglish,
+				"id3": language.Und,
+				"id4": language.Und,
+			},
+		},
+		{
+			name:        "selected instances",
+			instanceIDs: []string{"id1", "id2"},
+			mock: mockQueries(regexp.QuoteMeta(defaultLanguagesStmt+` WHERE projections.instances.id IN ($1,$2)`), cols,
+				[][]driver.Value{
+					{"id1", "de"},
+				},
+				"id1", "id2",
+			),
+			want: map[string]language.Tag{"id1": language.German},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.DefaultLanguages(context.Background(), tt.instanceIDs...)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
+func TestQueries_SearchInstancesWithOrgNames(t *testing.T) {
+	instancesWithOrgNameQuery := strings.Replace(instancesQuery,
+		` projections.instance_domains.sequence FROM`,
+		` projections.instance_domains.sequence, projections.orgs1.name FROM`, 1)
+	instancesWithOrgNameQuery = strings.Replace(instancesWithOrgNameQuery,
+		` LEFT JOIN projections.instance_domains ON f.id`,
+		` LEFT JOIN projections.orgs1 ON projections.instances.default_org_id = projections.orgs1.id AND projections.instances.id = projections.orgs1.instance_id`+
+			` LEFT JOIN projections.instance_domains ON f.id`, 1)
+	cols := append(slices.Clone(instancesCols), "name")
+	orgNameRow := func(id, domain string, orgName any) []driver.Value {
+		return append(instanceRow(id, domain), orgName)
+	}
+
+	execMock(t, mockQueries(regexp.QuoteMeta(instancesWithOrgNameQuery), cols,
+		[][]driver.Value{
+			orgNameRow("id1", "one.zitadel.cloud", "org one"),
+			orgNameRow("id1", "one.example.com", "org one"),
+			orgNameRow("id2", "two.zitadel.cloud", nil),
+		},
+	), func(db *sql.DB) {
+		q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+		got, err := q.SearchInstancesWithOrgNames(context.Background(), &InstanceSearchQueries{})
+		require.NoError(t, err)
+		require.Len(t, got.Instances, 2)
+		assert.Equal(t, "org one", got.Instances[0].DefaultOrgName)
+		assert.Len(t, got.Instances[0].Domains, 2)
+		assert.Empty(t, got.Instances[1].DefaultOrgName, "dangling default org")
+	})
+}
+
+func TestQueries_InstancesChangedSince(t *testing.T) {
+	changedAt := func(id string, changeDate time.Time) []driver.Value {
+		row := instanceRow(id, id+".zitadel.cloud")
+		row[3] = changeDate
+		return row
+	}
+	tests := []struct {
+		name  string
+		since map[string]time.Time
+		mock  sqlExpectation
+		want  []string
+	}{
+		{
+			name: "nothing synced",
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQuery),
+				instancesCols,
+				[][]driver.Value{changedAt("id1", dayNow), changedAt("id2", dayNow)},
+			),
+			want: []string{"id1", "id2"},
+		},
+		{
+			name: "changed and unknown instances",
+			since: map[string]time.Time{
+				// up to date
+				"id1": dayNow,
+				// changed after the sync
+				"id2": dayNow.Add(-time.Hour),
+			},
+			mock: mockQueries(
+				regexp.QuoteMeta(instancesQueryWhere(
+					"(NOT (projections.instances.id IN ($1,$2))"+
+						" OR (projections.instances.id = $3 AND projections.instances.change_date > $4)"+
+						" OR (projections.instances.id = $5 AND projections.instances.change_date > $6))",
+				)),
+				instancesCols,
+				[][]driver.Value{changedAt("id2", dayNow), changedAt("id3", dayNow)},
+				"id1", "id2", "id1", dayNow, "id2", dayNow.Add(-time.Hour),
+			),
+			want: []string{"id2", "id3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstancesChangedSince(context.Background(), tt.since)
+				require.NoError(t, err)
+				ids := make([]string, len(got.Instances))
+				for i, instance := range got.Instances {
+					ids[i] = instance.ID
+					if changed, ok := tt.since[instance.ID]; ok {
+						assert.True(t, instance.ChangeDate.After(changed))
+					}
+				}
+				assert.Equal(t, tt.want, ids)
+			})
+		})
+	}
+}
+
+var brokenConsoleInstancesQuery = regexp.QuoteMeta(strings.Replace(instancesQuery,
+	` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances`+
+		` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f`,
+	` FROM (SELECT projections.instances.id, COUNT(*) OVER () FROM projections.instances`+
+		` LEFT JOIN projections.apps7_oidc_configs ON projections.instances.console_app_id = projections.apps7_oidc_configs.app_id AND projections.instances.id = projections.apps7_oidc_configs.instance_id`+
+		` WHERE COALESCE(CARDINALITY(projections.apps7_oidc_configs.redirect_uris), 0) = 0) AS f`,
+	1,
+))
+
+func TestQueries_InstancesWithBrokenConsole(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxResultSize uint64
+		rows          [][]driver.Value
+		want          []string
+	}{
+		{
+			name: "healthy console app",
+			want: []string{},
+		},
+		{
+			name: "broken console app",
+			rows: [][]driver.Value{instanceRow("broken", "broken.zitadel.cloud")},
+			want: []string{"broken"},
+		},
+		{
+			name:          "not capped by max result size",
+			maxResultSize: 1,
+			rows: [][]driver.Value{
+				instanceRow("broken1", "broken1.zitadel.cloud"),
+				instanceRow("broken2", "broken2.zitadel.cloud"),
+			},
+			want: []string{"broken1", "broken2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, mockQueries(brokenConsoleInstancesQuery, instancesCols, tt.rows), func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}, maxInstanceResultSize: tt.maxResultSize}
+				got, err := q.InstancesWithBrokenConsole(context.Background())
+				require.NoError(t, err)
+				ids := make([]string, len(got.Instances))
+				for i, instance := range got.Instances {
+					ids[i] = instance.ID
+				}
+				assert.Equal(t, tt.want, ids)
+			})
+		})
+	}
+}
+
+func TestQueries_InstancesNeedingAttention(t *testing.T) {
+	row := func(id string, changeDate time.Time, isPrimary bool) []driver.Value {
+		r := instanceRow(id, id+".zitadel.cloud")
+		r[0] = uint64(4)
+		r[3] = changeDate
+		r[13] = isPrimary
+		return r
+	}
+	stale := time.Now().Add(-instanceStaleAfter - time.Hour)
+	rows := [][]driver.Value{
+		row("healthy", testNow, true),
+		row("stale", stale, true),
+		row("no-primary", testNow, false),
+		row("stale-no-primary", stale, false),
+	}
+	execMock(t, mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, rows), func(db *sql.DB) {
+		// all instances are checked, also if there are more than the max result size
+		q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}, maxInstanceResultSize: 1}
+		got, err := q.InstancesNeedingAttention(context.Background())
+		require.NoError(t, err)
+		require.Len(t, got.Instances, 3)
+		assert.Equal(t, uint64(3), got.Count)
+
+		want := map[string][]InstanceAttentionReason{
+			"stale":            {InstanceAttentionStale},
+			"no-primary":       {InstanceAttentionNoPrimaryDomain},
+			"stale-no-primary": {InstanceAttentionStale, InstanceAttentionNoPrimaryDomain},
+		}
+		for _, instance := range got.Instances {
+			require.Contains(t, want, instance.ID)
+			assert.Equal(t, want[instance.ID], instance.AttentionReasons, instance.ID)
+			assert.True(t, instance.NeedsAttention, instance.ID)
+		}
+	})
+}
+
+func TestQueries_InstanceHealthScores(t *testing.T) {
+	row := func(id string, changeDate time.Time, isPrimary bool) []driver.Value {
+		r := instanceRow(id, id+".zitadel.cloud")
+		r[3] = changeDate
+		r[13] = isPrimary
+		return r
+	}
+	stale := time.Now().Add(-instanceStaleAfter - time.Hour)
+	execMock(t,
+		func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+			mockQueries(brokenConsoleInstancesQuery, instancesCols, [][]driver.Value{
+				row("broken-console", testNow, true),
+				row("failing", stale, false),
+			})(m)
+			return mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{
+				row("healthy", testNow, true),
+				row("broken-console", testNow, true),
+				row("stale-no-primary", stale, false),
+				row("failing", stale, false),
+			})(m)
+		},
+		func(db *sql.DB) {
+			// the scores cover all instances, also if there are more than the max result size
+			q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}, maxInstanceResultSize: 1}
+			got, err := q.InstanceHealthScores(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{
+				"healthy":          100,
+				"broken-console":   70,
+				"stale-no-primary": 30,
+				"failing":          0,
+			}, got)
+		},
+	)
+}
+
+func TestQueries_InstancesChangedBetween(t *testing.T) {
+	stmt := regexp.QuoteMeta(strings.Replace(instancesQuery,
+		` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances`+
+			` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f`,
+		` FROM (SELECT projections.instances.id, projections.instances.sequence, COUNT(*) OVER () FROM projections.instances`+
+			` WHERE (projections.instances.sequence > $1 AND projections.instances.sequence <= $2)`+
+			` ORDER BY projections.instances.sequence, projections.instances.id LIMIT 10) AS f`,
+		1,
+	) + ` ORDER BY f.sequence, f.id`)
+	row := func(id string, sequence uint64) []driver.Value {
+		r := instanceRow(id, id+".zitadel.cloud")
+		r[0] = uint64(2)
+		r[4] = sequence
+		return r
+	}
+	tests := []struct {
+		name    string
+		fromSeq uint64
+		toSeq   uint64
+		mock    sqlExpectation
+		want    []uint64
+		wantErr func(error) bool
+	}{
+		{
+			name:    "window with instances",
+			fromSeq: 10,
+			toSeq:   20,
+			mock:    mockQueries(stmt, instancesCols, [][]driver.Value{row("id1", 11), row("id2", 20)}, uint64(10), uint64(20)),
+			want:    []uint64{11, 20},
+		},
+		{
+			name:    "empty window",
+			fromSeq: 20,
+			toSeq:   20,
+			mock:    mockQueries(stmt, instancesCols, nil, uint64(20), uint64(20)),
+			want:    []uint64{},
+		},
+		{
+			name:    "inverted window",
+			fromSeq: 21,
+			toSeq:   20,
+			mock:    func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr: zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstancesChangedBetween(context.Background(), tt.fromSeq, tt.toSeq, 10)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				sequences := make([]uint64, len(got.Instances))
+				for i, instance := range got.Instances {
+					sequences[i] = instance.Sequence
+				}
+				assert.Equal(t, tt.want, sequences)
+			})
+		})
+	}
+}
+
+func TestQueries_InstancesByPrimaryDomain(t *testing.T) {
+	secondaryRow := func(id, domain string) []driver.Value {
+		row := instanceRow(id, domain)
+		row[13] = false
+		return row
+	}
+	tests := []struct {
+		name          string
+		maxResultSize uint64
+		mock          sqlExpectation
+		want          map[string]string
+	}{
+		{
+			name: "keyed by primary domain",
+			mock: mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{
+				secondaryRow("id1", "one.example.com"),
+				instanceRow("id1", "one.zitadel.cloud"),
+				instanceRow("id2", "two.zitadel.cloud"),
+			}),
+			want: map[string]string{
+				"one.zitadel.cloud": "id1",
+				"two.zitadel.cloud": "id2",
+			},
+		},
+		{
+			name: "without primary domain omitted",
+			mock: mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{
+				instanceRow("id1", "one.zitadel.cloud"),
+				secondaryRow("id2", "two.example.com"),
+			}),
+			want: map[string]string{
+				"one.zitadel.cloud": "id1",
+			},
+		},
+		{
+			name:          "more instances than max result size",
+			maxResultSize: 1,
+			mock: mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{
+				instanceRow("id1", "one.zitadel.cloud"),
+				instanceRow("id2", "two.zitadel.cloud"),
+				instanceRow("id3", "three.zitadel.cloud"),
+			}),
+			want: map[string]string{
+				"one.zitadel.cloud":   "id1",
+				"two.zitadel.cloud":   "id2",
+				"three.zitadel.cloud": "id3",
+			},
+		},
+		{
+			name: "no instances",
+			mock: mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, nil),
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}, maxInstanceResultSize: tt.maxResultSize}
+				got, err := q.InstancesByPrimaryDomain(context.Background())
+				require.NoError(t, err)
+				ids := make(map[string]string, len(got))
+				for domain, instance := range got {
+					ids[domain] = instance.ID
+				}
+				assert.Equal(t, tt.want, ids)
+			})
+		})
+	}
+}
+
+func TestQueries_RecentInstances(t *testing.T) {
+	stmt := regexp.QuoteMeta(strings.Replace(instancesQuery,
+		` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances`+
+			` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f`,
+		` FROM (SELECT projections.instances.id, projections.instances.creation_date, COUNT(*) OVER () FROM projections.instances`+
+			` ORDER BY projections.instances.creation_date DESC, projections.instances.id LIMIT 2) AS f`,
+		1,
+	) + ` ORDER BY f.creation_date DESC, f.id`)
+	row := func(count uint64, id string, created time.Time) []driver.Value {
+		r := instanceRow(id, id+".zitadel.cloud")
+		r[0] = count
+		r[2] = created
+		return r
+	}
+	tests := []struct {
+		name      string
+		mock      sqlExpectation
+		wantIDs   []string
+		wantCount uint64
+	}{
+		{
+			name: "more instances than n",
+			mock: mockQueries(stmt, instancesCols, [][]driver.Value{
+				row(3, "id3", testNow),
+				row(3, "id2", testNow.Add(-time.Hour)),
+			}),
+			wantIDs:   []string{"id3", "id2"},
+			wantCount: 3,
+		},
+		{
+			name: "fewer instances than n",
+			mock: mockQueries(stmt, instancesCols, [][]driver.Value{
+				row(1, "id1", testNow),
+			}),
+			wantIDs:   []string{"id1"},
+			wantCount: 1,
+		},
+		{
+			name:    "no instances",
+			mock:    mockQueries(stmt, instancesCols, nil),
+			wantIDs: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.RecentInstances(context.Background(), 2)
+				require.NoError(t, err)
+				ids := make([]string, len(got.Instances))
+				for i, instance := range got.Instances {
+					ids[i] = instance.ID
+				}
+				assert.Equal(t, tt.wantIDs, ids)
+				assert.Equal(t, tt.wantCount, got.Count)
+			})
+		})
+	}
+}
+
+func TestQueries_SearchInstancesAfterName(t *testing.T) {
+	pageQuery := func(where string) string {
+		return regexp.QuoteMeta(strings.Replace(instancesQuery,
+			` FROM (SELECT DISTINCT projections.instances.id, COUNT(*) OVER () FROM projections.instances`+
+				` LEFT JOIN projections.instance_domains ON projections.instances.id = projections.instance_domains.instance_id) AS f`,
+			` FROM (SELECT projections.instances.id, projections.instances.name, COUNT(*) OVER () FROM projections.instances`+
+				where+
+				` ORDER BY projections.instances.name, projections.instances.id LIMIT 2) AS f`,
+			1,
+		) + ` ORDER BY f.name, f.id`)
+	}
+	firstPage := pageQuery("")
+	nextPage := pageQuery(` WHERE (projections.instances.name, projections.instances.id) > ($1, $2)`)
+	// instanceRow names the instance "name-" + id
+	row := func(count uint64, id string) []driver.Value {
+		r := instanceRow(id, id+".zitadel.cloud")
+		r[0] = count
+		return r
+	}
+	ids := func(instances *Instances) []string {
+		ids := make([]string, len(instances.Instances))
+		for i, instance := range instances.Instances {
+			ids[i] = instance.ID
+		}
+		return ids
+	}
+
+	t.Run("instance inserted before the position", func(t *testing.T) {
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(firstPage, instancesCols, [][]driver.Value{row(4, "b"), row(4, "c")})(m)
+				// "a" was added after the first page was read, it is sorted before the position and must not shift the next page
+				return mockQueries(nextPage, instancesCols, [][]driver.Value{row(2, "d"), row(2, "e")}, "name-c", "c")(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				page, err := q.SearchInstancesAfterName(context.Background(), "", "", 2)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"b", "c"}, ids(page))
+				last := page.Instances[len(page.Instances)-1]
+
+				page, err = q.SearchInstancesAfterName(context.Background(), last.Name, last.ID, 2)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"d", "e"}, ids(page))
+				assert.Equal(t, uint64(2), page.Count)
+			},
+		)
+	})
+	t.Run("instance inserted after the position", func(t *testing.T) {
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(firstPage, instancesCols, [][]driver.Value{row(3, "b"), row(3, "c")})(m)
+				// "cc" was added after the first page was read and is returned on the next page
+				return mockQueries(nextPage, instancesCols, [][]driver.Value{row(2, "cc"), row(2, "d")}, "name-c", "c")(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				page, err := q.SearchInstancesAfterName(context.Background(), "", "", 2)
+				require.NoError(t, err)
+				seen := ids(page)
+				last := page.Instances[len(page.Instances)-1]
+
+				page, err = q.SearchInstancesAfterName(context.Background(), last.Name, last.ID, 2)
+				require.NoError(t, err)
+				seen = append(seen, ids(page)...)
+				assert.Equal(t, []string{"b", "c", "cc", "d"}, seen)
+			},
+		)
+	})
+	t.Run("limit exceeded", func(t *testing.T) {
+		q := &Queries{maxInstanceResultSize: 1}
+		_, err := q.SearchInstancesAfterName(context.Background(), "", "", 2)
+		require.ErrorIs(t, err, ErrInstanceResultSizeExceeded)
+	})
+}
+
+func TestQueries_InstanceProjectionLag(t *testing.T) {
+	const projectionSeqQuery = `SELECT projections.instances.sequence FROM projections.instances WHERE projections.instances.id = $1`
+	expectLatestSequence := func(sequence float64) expect {
+		return func(m *mock.MockRepository) {
+			m.MockQuerier.EXPECT().LatestSequence(gomock.Any(), gomock.Any()).Return(sequence, nil)
+		}
+	}
+	tests := []struct {
+		name              string
+		eventstore        func(*testing.T) *eventstore.Eventstore
+		mock              sqlExpectation
+		wantEventstoreSeq uint64
+		wantProjectionSeq uint64
+		wantErr           func(error) bool
+	}{
+		{
+			name:              "projection lags",
+			eventstore:        expectEventstore(expectLatestSequence(12)),
+			mock:              mockQuery(regexp.QuoteMeta(projectionSeqQuery), []string{"sequence"}, []driver.Value{uint64(9)}, "instanceID"),
+			wantEventstoreSeq: 12,
+			wantProjectionSeq: 9,
+		},
+		{
+			name:              "projection up to date",
+			eventstore:        expectEventstore(expectLatestSequence(12)),
+			mock:              mockQuery(regexp.QuoteMeta(projectionSeqQuery), []string{"sequence"}, []driver.Value{uint64(12)}, "instanceID"),
+			wantEventstoreSeq: 12,
+			wantProjectionSeq: 12,
+		},
+		{
+			name:       "not projected",
+			eventstore: expectEventstore(expectLatestSequence(1)),
+			mock:       mockQueryErr(regexp.QuoteMeta(projectionSeqQuery), sql.ErrNoRows, "instanceID"),
+			wantErr:    zerrors.IsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{
+					eventstore: tt.eventstore(t),
+					client:     &database.DB{DB: db, Database: new(prepareDB)},
+				}
+				eventstoreSeq, projectionSeq, err := q.InstanceProjectionLag(context.Background(), "instanceID")
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantEventstoreSeq, eventstoreSeq)
+				assert.Equal(t, tt.wantProjectionSeq, projectionSeq)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceWithQuotas(t *testing.T) {
+	quotasQuery := regexp.QuoteMeta(`SELECT projections.quotas.unit, projections.quotas.id, projections.quotas.from_anchor, projections.quotas.interval,` +
+		` projections.quotas.amount, projections.quotas.limit_usage, now() FROM projections.quotas WHERE projections.quotas.instance_id = $1`)
+	quotasCols := []string{"unit", "id", "from_anchor", "interval", "amount", "limit_usage", "now"}
+	instanceMock := mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")[1:]}, "instanceID")
+	ctx := authz.WithInstanceID(context.Background(), "instanceID")
+
+	tests := []struct {
+		name       string
+		mock       sqlExpectation
+		wantQuotas *InstanceQuotas
+		wantErr    func(error) bool
+	}{
+		{
+			name: "with quotas",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				instanceMock(m)
+				return mockQueries(quotasQuery, quotasCols, [][]driver.Value{
+					{quota.RequestsAllAuthenticated, "requests", dayNow, &pgtype.Interval{Days: 1, Valid: true}, uint64(1000), true, dayNow},
+					{quota.ActionsAllRunsSeconds, "actions", dayNow, &pgtype.Interval{Days: 1, Valid: true}, uint64(60), false, dayNow},
+				}, "instanceID")(m)
+			},
+			wantQuotas: &InstanceQuotas{
+				RequestsAllAuthenticated: &Quota{ID: "requests", From: dayNow, ResetInterval: 24 * time.Hour, Amount: 1000, Limit: true, CurrentPeriodStart: dayNow},
+				ActionsAllRunsSeconds:    &Quota{ID: "actions", From: dayNow, ResetInterval: 24 * time.Hour, Amount: 60, CurrentPeriodStart: dayNow},
+			},
+		},
+		{
+			name: "without quotas",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				instanceMock(m)
+				return mockQueries(quotasQuery, quotasCols, nil, "instanceID")(m)
+			},
+		},
+		{
+			name:    "instance not found",
+			mock:    mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, nil, "instanceID"),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name: "quotas error",
+			mock: func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				instanceMock(m)
+				return mockQueryErr(quotasQuery, sql.ErrConnDone, "instanceID")(m)
+			},
+			wantErr: zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				instance, quotas, err := q.InstanceWithQuotas(ctx)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, "instanceID", instance.ID)
+				assert.Equal(t, tt.wantQuotas, quotas)
+			})
+		})
+	}
+}
+
+func TestQueries_InstanceCreationEvent(t *testing.T) {
+	instanceAdded := func() eventstore.Event {
+		event := eventFromEventPusher(instance_repo.NewInstanceAddedEvent(context.Background(),
+			&instance_repo.NewAggregate("instanceID").Aggregate,
+			"instance",
+		))
+		event.EditorUser = "creator"
+		event.CreationDate = testNow
+		event.Seq = 1
+		return event
+	}
+	tests := []struct {
+		name       string
+		eventstore func(*testing.T) *eventstore.Eventstore
+		want       *InstanceEvent
+		wantErr    func(error) bool
+	}{
+		{
+			name:       "creation event",
+			eventstore: expectEventstore(expectFilter(instanceAdded())),
+			want: &InstanceEvent{
+				InstanceID:   "instanceID",
+				Creator:      "creator",
+				CreationDate: testNow,
+				Sequence:     1,
+			},
+		},
+		{
+			name:       "legacy instance without creation event",
+			eventstore: expectEventstore(expectFilter()),
+			wantErr:    zerrors.IsNotFound,
+		},
+		{
+			name:       "eventstore error",
+			eventstore: expectEventstore(expectFilterError(io.ErrClosedPipe)),
+			wantErr: func(err error) bool {
+				return errors.Is(err, io.ErrClosedPipe)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Queries{eventstore: tt.eventstore(t)}
+			got, err := q.InstanceCreationEvent(context.Background(), "instanceID")
+			if tt.wantErr != nil {
+				require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestQueries_InstanceOnConn(t *testing.T) {
+	row := instanceRow("instanceID", "test.zitadel.cloud")[1:]
+	ctx := authz.WithInstanceID(context.Background(), "instanceID")
+
+	// the client has no expectations, so the query must run on the passed connection
+	client, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer client.Close()
+
+	execMock(t, mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{row}, "instanceID"), func(db *sql.DB) {
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		q := &Queries{client: &database.DB{DB: client, Database: new(prepareDB)}}
+		got, err := q.InstanceOnConn(ctx, conn)
+		require.NoError(t, err)
+		assert.Equal(t, "instanceID", got.ID)
+		assert.Len(t, got.Domains, 1)
+	})
+}
+
+func TestQueries_InstanceAfterChangeDate(t *testing.T) {
+	pollInterval := instanceChangeDatePollInterval
+	t.Cleanup(func() { instanceChangeDatePollInterval = pollInterval })
+
+	minChange := testNow.Add(time.Second)
+	rowChangedAt := func(changeDate time.Time) []driver.Value {
+		row := instanceRow("instanceID", "test.zitadel.cloud")[1:]
+		row[2] = changeDate
+		return row
+	}
+	ctx := authz.WithInstanceID(context.Background(), "instanceID")
+
+	t.Run("projection lag resolves", func(t *testing.T) {
+		instanceChangeDatePollInterval = time.Millisecond
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{rowChangedAt(testNow)}, "instanceID")(m)
+				mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{rowChangedAt(testNow)}, "instanceID")(m)
+				return mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{rowChangedAt(minChange)}, "instanceID")(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				got, err := q.InstanceAfterChangeDate(ctx, minChange)
+				require.NoError(t, err)
+				assert.Equal(t, minChange, got.ChangeDate)
+			},
+		)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		instanceChangeDatePollInterval = time.Hour
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				return mockQueries(regexp.QuoteMeta(instanceQuery), instanceCols, [][]driver.Value{rowChangedAt(testNow)}, "instanceID")(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				_, err := q.InstanceAfterChangeDate(ctx, minChange)
+				require.ErrorIs(t, err, context.DeadlineExceeded)
+				assert.True(t, zerrors.IsDeadlineExceeded(err))
+			},
+		)
+	})
+}
+
+func TestQueries_WithConsistency(t *testing.T) {
+	pollInterval := consistencyPollInterval
+	t.Cleanup(func() { consistencyPollInterval = pollInterval })
+
+	const sequenceQuery = `SELECT projections.instances.sequence FROM projections.instances WHERE projections.instances.id = $1`
+	mockSequence := func(sequence uint64) sqlExpectation {
+		return mockQuery(regexp.QuoteMeta(sequenceQuery), []string{"sequence"}, []driver.Value{sequence}, "instanceID")
+	}
+	token := ConsistencyToken{Sequences: map[string]uint64{"instanceID": 20211109}}
+
+	t.Run("read blocks until projection caught up", func(t *testing.T) {
+		consistencyPollInterval = time.Millisecond
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockSequence(20211108)(m)
+				mockSequence(20211108)(m)
+				mockSequence(20211109)(m)
+				return mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")})(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				got, err := q.SearchInstances(q.WithConsistency(ctx, token), &InstanceSearchQueries{})
+				require.NoError(t, err)
+				require.Len(t, got.Instances, 1)
+				assert.Equal(t, "instanceID", got.Instances[0].ID)
+			},
+		)
+	})
+
+	t.Run("instance not projected yet", func(t *testing.T) {
+		consistencyPollInterval = time.Millisecond
+		execMock(t,
+			func(m sqlmock.Sqlmock) sqlmock.Sqlmock {
+				mockQuery(regexp.QuoteMeta(sequenceQuery), []string{"sequence"}, nil, "instanceID")(m)
+				mockSequence(20211110)(m)
+				return mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, [][]driver.Value{instanceRow("instanceID", "test.zitadel.cloud")})(m)
+			},
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_, err := q.SearchInstances(q.WithConsistency(ctx, token), &InstanceSearchQueries{})
+				require.NoError(t, err)
+			},
+		)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		consistencyPollInterval = time.Hour
+		execMock(t,
+			mockSequence(20211108),
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				_, err := q.SearchInstances(q.WithConsistency(ctx, token), &InstanceSearchQueries{})
+				require.ErrorIs(t, err, context.DeadlineExceeded)
+				assert.True(t, zerrors.IsDeadlineExceeded(err))
+			},
+		)
+	})
+
+	t.Run("without token", func(t *testing.T) {
+		execMock(t,
+			mockQueries(regexp.QuoteMeta(instancesQuery), instancesCols, nil),
+			func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				_, err := q.SearchInstances(context.Background(), &InstanceSearchQueries{})
+				require.NoError(t, err)
+			},
+		)
+	})
+}
+
+func TestInstanceSearchQueries_shuffle(t *testing.T) {
+	stmt := func(queries *InstanceSearchQueries) (string, []interface{}) {
+		filter, query, _ := prepareInstancesQuery(context.Background(), new(prepareDB))
+		stmt, args, err := queries.shuffle(queries.toQuery(filter), query).ToSql()
+		require.NoError(t, err)
+		return stmt, args
+	}
+
+	stmt1, args1 := stmt(&InstanceSearchQueries{ShuffleSeed: "seed-1"})
+	stmt2, args2 := stmt(&InstanceSearchQueries{ShuffleSeed: "seed-1"})
+	assert.Equal(t, stmt1, stmt2)
+	assert.Equal(t, args1, args2, "same seed must result in the same order")
+
+	stmt3, args3 := stmt(&InstanceSearchQueries{ShuffleSeed: "seed-2"})
+	assert.Equal(t, stmt1, stmt3)
+	assert.NotEqual(t, args1, args3, "different seeds must result in a different order")
+
+	paged, args := stmt(&InstanceSearchQueries{SearchRequest: SearchRequest{Limit: 10, Offset: 20}, ShuffleSeed: "seed-1"})
+	assert.Contains(t, paged, "md5(projections.instances.id || $1) AS shuffle_key")
+	assert.Contains(t, paged, " ORDER BY shuffle_key LIMIT 10 OFFSET 20) AS f", "the filter must be ordered before limit and offset")
+	assert.True(t, strings.HasSuffix(paged, " ORDER BY f.shuffle_key"), "the query must keep the order of the filter")
+	assert.Equal(t, []interface{}{"seed-1"}, args)
+
+	unshuffled, args := stmt(&InstanceSearchQueries{})
+	assert.NotContains(t, unshuffled, "ORDER BY")
+	assert.Empty(t, args)
+}
+
+func TestQueries_TopInstancesByUsers(t *testing.T) {
+	const topInstancesStmt = `SELECT projections.instances.id, COUNT(projections.users13.id), projections.instances.name FROM projections.instances` +
+		` LEFT JOIN projections.users13 ON (projections.users13.instance_id = projections.instances.id AND projections.users13.state NOT IN ($1,$2)) AS OF SYSTEM TIME '-1 ms'` +
+		` GROUP BY projections.instances.id, projections.instances.name` +
+		` ORDER BY COUNT(projections.users13.id) DESC, projections.instances.id`
+	cols := []string{"id", "amount", "name"}
+	rows := [][]driver.Value{
+		{"id3", uint64(120), "big"},
+		{"id1", uint64(3), "small"},
+	}
+	want := []*InstanceUserCount{
+		{InstanceID: "id3", Name: "big", Count: 120},
+		{InstanceID: "id1", Name: "small", Count: 3},
+	}
+	tests := []struct {
+		name          string
+		maxResultSize uint64
+		limit         uint64
+		mock          sqlExpectation
+		want          []*InstanceUserCount
+		wantErr       func(error) bool
+	}{
+		{
+			name:  "limit",
+			limit: 2,
+			mock:  mockQueries(regexp.QuoteMeta(topInstancesStmt+` LIMIT 2`), cols, rows, domain.UserStateInactive, domain.UserStateDeleted),
+			want:  want,
+		},
+		{
+			name: "without limit",
+			mock: mockQueries(regexp.QuoteMeta(topInstancesStmt), cols, rows, domain.UserStateInactive, domain.UserStateDeleted),
+			want: want,
+		},
+		{
+			name:          "without limit, capped",
+			maxResultSize: 10,
+			mock:          mockQueries(regexp.QuoteMeta(topInstancesStmt+` LIMIT 10`), cols, rows, domain.UserStateInactive, domain.UserStateDeleted),
+			want:          want,
+		},
+		{
+			name:          "limit exceeds cap",
+			maxResultSize: 10,
+			limit:         math.MaxUint64,
+			mock:          func(m sqlmock.Sqlmock) sqlmock.Sqlmock { return m },
+			wantErr:       zerrors.IsErrorInvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{
+					client:                &database.DB{DB: db, Database: new(prepareDB)},
+					maxInstanceResultSize: tt.maxResultSize,
+				}
+				got, err := q.TopInstancesByUsers(context.Background(), tt.limit)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
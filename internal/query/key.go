@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rsa"
 	"database/sql"
+	"errors"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -244,6 +245,46 @@ func (q *Queries) ActivePrivateSigningKey(ctx context.Context, t time.Time) (key
 	return keys, nil
 }
 
+// DefaultSigningAlgorithm is returned by [Queries.InstanceSigningAlgorithm]
+// if the instance has no valid signing key yet, as new key pairs are generated with it.
+const DefaultSigningAlgorithm = "RS256"
+
+// InstanceSigningAlgorithm returns the algorithm of the signing key pair of the instance
+// which is currently used to sign tokens, or [DefaultSigningAlgorithm] if there is none.
+// Instances with the web key feature sign with the key returned by [Queries.GetActiveSigningWebKey] instead.
+func (q *Queries) InstanceSigningAlgorithm(ctx context.Context) (algorithm string, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	query, args, err := sq.Select(KeyColAlgorithm.identifier()).
+		From(keyTable.identifier()).
+		LeftJoin(join(KeyPrivateColID, KeyColID) + q.client.Timetravel(call.Took(ctx))).
+		Where(sq.And{
+			sq.Eq{
+				KeyColUse.identifier():        crypto.KeyUsageSigning,
+				KeyColInstanceID.identifier(): authz.GetInstance(ctx).InstanceID(),
+			},
+			sq.Gt{KeyPrivateColExpiry.identifier(): time.Now()},
+		}).
+		OrderBy(KeyPrivateColExpiry.identifier()).
+		Limit(1).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return "", zerrors.ThrowInternal(err, "QUERY-Ue7ah", "Errors.Query.SQLStatement")
+	}
+	err = q.client.QueryRowContext(ctx, func(row *sql.Row) error {
+		return row.Scan(&algorithm)
+	}, query, args...)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && algorithm == "") {
+		return DefaultSigningAlgorithm, nil
+	}
+	if err != nil {
+		return "", zerrors.ThrowInternal(err, "QUERY-ohW8i", "Errors.Internal")
+	}
+	return algorithm, nil
+}
+
 func preparePublicKeysQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilder, func(*sql.Rows) (*PublicKeys, error)) {
 	return sq.Select(
 			KeyColID.identifier(),
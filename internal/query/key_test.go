@@ -13,12 +13,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
 	"github.com/zitadel/zitadel/internal/api/authz"
 	"github.com/zitadel/zitadel/internal/crypto"
+	"github.com/zitadel/zitadel/internal/database"
 	"github.com/zitadel/zitadel/internal/eventstore"
 	key_repo "github.com/zitadel/zitadel/internal/repository/keypair"
 	"github.com/zitadel/zitadel/internal/zerrors"
@@ -268,6 +270,53 @@ MZbmlCoBru+rC8ITlTX/0V1ZcsSbL8tYWhthyu9x6yjo1bH85wiVI4gs0MhU8f2a
 -----END PUBLIC KEY-----
 `
 
+func TestQueries_InstanceSigningAlgorithm(t *testing.T) {
+	const stmt = `SELECT projections.keys4.algorithm FROM projections.keys4` +
+		` LEFT JOIN projections.keys4_private ON projections.keys4.id = projections.keys4_private.id AND projections.keys4.instance_id = projections.keys4_private.instance_id` +
+		` AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE (projections.keys4.instance_id = $1 AND projections.keys4.use = $2 AND projections.keys4_private.expiry > $3)` +
+		` ORDER BY projections.keys4_private.expiry LIMIT 1`
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    string
+		wantErr error
+	}{
+		{
+			name: "configured algorithm",
+			mock: mockQuery(regexp.QuoteMeta(stmt), []string{"algorithm"}, []driver.Value{"ES256"},
+				"instanceID", crypto.KeyUsageSigning, sqlmock.AnyArg()),
+			want: "ES256",
+		},
+		{
+			name: "default",
+			mock: mockQueryErr(regexp.QuoteMeta(stmt), sql.ErrNoRows,
+				"instanceID", crypto.KeyUsageSigning, sqlmock.AnyArg()),
+			want: DefaultSigningAlgorithm,
+		},
+		{
+			name: "sql error",
+			mock: mockQueryErr(regexp.QuoteMeta(stmt), sql.ErrConnDone,
+				"instanceID", crypto.KeyUsageSigning, sqlmock.AnyArg()),
+			wantErr: sql.ErrConnDone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceSigningAlgorithm(authz.WithInstanceID(context.Background(), "instanceID"))
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
+
 func TestQueries_GetPublicKeyByID(t *testing.T) {
 	now := time.Now()
 	future := now.Add(time.Hour)
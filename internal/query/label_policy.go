@@ -132,6 +132,52 @@ func (q *Queries) DefaultActiveLabelPolicy(ctx context.Context) (policy *LabelPo
 	return policy, err
 }
 
+const (
+	// DefaultBrandingPrimaryColor and DefaultBrandingBackgroundColor are the colors of the default label policy of new instances.
+	DefaultBrandingPrimaryColor    = "#5469d4"
+	DefaultBrandingBackgroundColor = "#fafafa"
+)
+
+// Branding is the effective branding of an instance.
+type Branding struct {
+	PrimaryColor    string
+	BackgroundColor string
+	LogoURL         string
+}
+
+// InstanceBranding returns the branding of the active default label policy of the instance in ctx.
+// The theme of the dark mode is used if the policy only allows the dark mode, the theme of the light mode otherwise.
+// Unset colors fall back to [DefaultBrandingPrimaryColor] and [DefaultBrandingBackgroundColor],
+// the logo URL is empty if no logo is set.
+func (q *Queries) InstanceBranding(ctx context.Context) (branding *Branding, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	branding = &Branding{
+		PrimaryColor:    DefaultBrandingPrimaryColor,
+		BackgroundColor: DefaultBrandingBackgroundColor,
+	}
+	policy, err := q.DefaultActiveLabelPolicy(ctx)
+	if zerrors.IsNotFound(err) {
+		return branding, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	theme := policy.Light
+	if policy.ThemeMode == domain.LabelPolicyThemeDark {
+		theme = policy.Dark
+	}
+	if theme.PrimaryColor != "" {
+		branding.PrimaryColor = theme.PrimaryColor
+	}
+	if theme.BackgroundColor != "" {
+		branding.BackgroundColor = theme.BackgroundColor
+	}
+	branding.LogoURL = theme.LogoURL
+	return branding, nil
+}
+
 func (q *Queries) DefaultPreviewLabelPolicy(ctx context.Context) (policy *LabelPolicy, err error) {
 	ctx, span := tracing.NewSpan(ctx)
 	defer func() { span.EndWithError(err) }()
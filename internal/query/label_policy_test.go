@@ -0,0 +1,168 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/api/authz"
+	"github.com/zitadel/zitadel/internal/database"
+	"github.com/zitadel/zitadel/internal/domain"
+	"github.com/zitadel/zitadel/internal/zerrors"
+)
+
+var (
+	defaultActiveLabelPolicyStmt = `SELECT creation_date,` +
+		` change_date,` +
+		` sequence,` +
+		` id,` +
+		` state,` +
+		` is_default,` +
+		` resource_owner,` +
+		` hide_login_name_suffix,` +
+		` font_url,` +
+		` watermark_disabled,` +
+		` should_error_popup,` +
+		` theme_mode,` +
+		` light_primary_color,` +
+		` light_warn_color,` +
+		` light_background_color,` +
+		` light_font_color,` +
+		` light_logo_url,` +
+		` light_icon_url,` +
+		` dark_primary_color,` +
+		` dark_warn_color,` +
+		` dark_background_color,` +
+		` dark_font_color,` +
+		` dark_logo_url,` +
+		` dark_icon_url` +
+		` FROM projections.label_policies3 AS OF SYSTEM TIME '-1 ms'` +
+		` WHERE id = $1` +
+		` AND instance_id = $2` +
+		` AND state = $3` +
+		` ORDER BY is_default LIMIT 1`
+	labelPolicyCols = []string{
+		"creation_date",
+		"change_date",
+		"sequence",
+		"id",
+		"state",
+		"is_default",
+		"resource_owner",
+		"hide_login_name_suffix",
+		"font_url",
+		"watermark_disabled",
+		"should_error_popup",
+		"theme_mode",
+		"light_primary_color",
+		"light_warn_color",
+		"light_background_color",
+		"light_font_color",
+		"light_logo_url",
+		"light_icon_url",
+		"dark_primary_color",
+		"dark_warn_color",
+		"dark_background_color",
+		"dark_font_color",
+		"dark_logo_url",
+		"dark_icon_url",
+	}
+)
+
+func TestQueries_InstanceBranding(t *testing.T) {
+	policyRow := func(themeMode domain.LabelPolicyThemeMode, light, dark Theme) []driver.Value {
+		nullable := func(s string) driver.Value {
+			if s == "" {
+				return nil
+			}
+			return s
+		}
+		return []driver.Value{
+			testNow, testNow, uint64(20211108), "instance-id", domain.LabelPolicyStateActive, true, "instance-id",
+			false, nil, false, false, themeMode,
+			nullable(light.PrimaryColor), nil, nullable(light.BackgroundColor), nil, nullable(light.LogoURL), nil,
+			nullable(dark.PrimaryColor), nil, nullable(dark.BackgroundColor), nil, nullable(dark.LogoURL), nil,
+		}
+	}
+	args := []driver.Value{"instance-id", "instance-id", domain.LabelPolicyStateActive}
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    *Branding
+		wantErr func(error) bool
+	}{
+		{
+			name: "custom branding",
+			mock: mockQuery(regexp.QuoteMeta(defaultActiveLabelPolicyStmt), labelPolicyCols,
+				policyRow(domain.LabelPolicyThemeAuto,
+					Theme{PrimaryColor: "#ff0000", BackgroundColor: "#000000", LogoURL: "instance-id/policy/label/logo-light"},
+					Theme{PrimaryColor: "#00ff00", BackgroundColor: "#ffffff", LogoURL: "instance-id/policy/label/logo-dark"},
+				),
+				args...,
+			),
+			want: &Branding{
+				PrimaryColor:    "#ff0000",
+				BackgroundColor: "#000000",
+				LogoURL:         "instance-id/policy/label/logo-light",
+			},
+		},
+		{
+			name: "dark mode only",
+			mock: mockQuery(regexp.QuoteMeta(defaultActiveLabelPolicyStmt), labelPolicyCols,
+				policyRow(domain.LabelPolicyThemeDark,
+					Theme{PrimaryColor: "#ff0000"},
+					Theme{PrimaryColor: "#00ff00", LogoURL: "instance-id/policy/label/logo-dark"},
+				),
+				args...,
+			),
+			want: &Branding{
+				PrimaryColor:    "#00ff00",
+				BackgroundColor: DefaultBrandingBackgroundColor,
+				LogoURL:         "instance-id/policy/label/logo-dark",
+			},
+		},
+		{
+			name: "unset colors",
+			mock: mockQuery(regexp.QuoteMeta(defaultActiveLabelPolicyStmt), labelPolicyCols,
+				policyRow(domain.LabelPolicyThemeAuto, Theme{}, Theme{}),
+				args...,
+			),
+			want: &Branding{
+				PrimaryColor:    DefaultBrandingPrimaryColor,
+				BackgroundColor: DefaultBrandingBackgroundColor,
+			},
+		},
+		{
+			name: "no label policy",
+			mock: mockQueryErr(regexp.QuoteMeta(defaultActiveLabelPolicyStmt), sql.ErrNoRows, args...),
+			want: &Branding{
+				PrimaryColor:    DefaultBrandingPrimaryColor,
+				BackgroundColor: DefaultBrandingBackgroundColor,
+			},
+		},
+		{
+			name:    "sql error",
+			mock:    mockQueryErr(regexp.QuoteMeta(defaultActiveLabelPolicyStmt), sql.ErrConnDone, args...),
+			wantErr: zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceBranding(authz.WithInstanceID(context.Background(), "instance-id"))
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
@@ -95,6 +95,57 @@ func (q *Queries) OIDCSettingsByAggID(ctx context.Context, aggregateID string) (
 	return settings, err
 }
 
+const (
+	// DefaultAccessTokenLifetime, DefaultIDTokenLifetime, DefaultRefreshTokenIdleExpiration and DefaultRefreshTokenExpiration
+	// are the token lifetimes of the default OIDC settings of new instances.
+	DefaultAccessTokenLifetime        = 12 * time.Hour
+	DefaultIDTokenLifetime            = 12 * time.Hour
+	DefaultRefreshTokenIdleExpiration = 30 * 24 * time.Hour
+	DefaultRefreshTokenExpiration     = 90 * 24 * time.Hour
+)
+
+// TokenLifetimes are the effective token lifetimes of an instance.
+type TokenLifetimes struct {
+	AccessToken          time.Duration
+	IDToken              time.Duration
+	RefreshTokenIdle     time.Duration
+	RefreshTokenAbsolute time.Duration
+}
+
+// InstanceTokenLifetimes returns the token lifetimes of the OIDC settings of the instance in ctx.
+// Lifetimes which are not set fall back to the defaults, as well as all lifetimes if the instance has no OIDC settings.
+func (q *Queries) InstanceTokenLifetimes(ctx context.Context) (lifetimes *TokenLifetimes, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	lifetimes = &TokenLifetimes{
+		AccessToken:          DefaultAccessTokenLifetime,
+		IDToken:              DefaultIDTokenLifetime,
+		RefreshTokenIdle:     DefaultRefreshTokenIdleExpiration,
+		RefreshTokenAbsolute: DefaultRefreshTokenExpiration,
+	}
+	settings, err := q.OIDCSettingsByAggID(ctx, authz.GetInstance(ctx).InstanceID())
+	if zerrors.IsNotFound(err) {
+		return lifetimes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if settings.AccessTokenLifetime > 0 {
+		lifetimes.AccessToken = settings.AccessTokenLifetime
+	}
+	if settings.IdTokenLifetime > 0 {
+		lifetimes.IDToken = settings.IdTokenLifetime
+	}
+	if settings.RefreshTokenIdleExpiration > 0 {
+		lifetimes.RefreshTokenIdle = settings.RefreshTokenIdleExpiration
+	}
+	if settings.RefreshTokenExpiration > 0 {
+		lifetimes.RefreshTokenAbsolute = settings.RefreshTokenExpiration
+	}
+	return lifetimes, nil
+}
+
 func prepareOIDCSettingsQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilder, func(*sql.Row) (*OIDCSettings, error)) {
 	return sq.Select(
 			OIDCSettingsColumnAggregateID.identifier(),
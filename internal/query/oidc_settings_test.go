@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -9,6 +10,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/api/authz"
+	"github.com/zitadel/zitadel/internal/database"
 	"github.com/zitadel/zitadel/internal/zerrors"
 )
 
@@ -122,3 +128,67 @@ func Test_OIDCConfigsPrepares(t *testing.T) {
 		})
 	}
 }
+
+func TestQueries_InstanceTokenLifetimes(t *testing.T) {
+	stmt := regexp.QuoteMeta(prepareOIDCSettingsStmt +
+		` WHERE projections.oidc_settings2.aggregate_id = $1 AND projections.oidc_settings2.instance_id = $2`)
+	settingsRow := func(accessToken, idToken, refreshTokenIdle, refreshToken time.Duration) []driver.Value {
+		return []driver.Value{"instance-id", testNow, testNow, "instance-id", uint64(20211108), accessToken, idToken, refreshTokenIdle, refreshToken}
+	}
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    *TokenLifetimes
+		wantErr func(error) bool
+	}{
+		{
+			name: "configured",
+			mock: mockQuery(stmt, prepareOIDCSettingsCols, settingsRow(time.Hour, 2*time.Hour, 24*time.Hour, 48*time.Hour), "instance-id", "instance-id"),
+			want: &TokenLifetimes{
+				AccessToken:          time.Hour,
+				IDToken:              2 * time.Hour,
+				RefreshTokenIdle:     24 * time.Hour,
+				RefreshTokenAbsolute: 48 * time.Hour,
+			},
+		},
+		{
+			name: "partially configured",
+			mock: mockQuery(stmt, prepareOIDCSettingsCols, settingsRow(time.Hour, 0, 0, 0), "instance-id", "instance-id"),
+			want: &TokenLifetimes{
+				AccessToken:          time.Hour,
+				IDToken:              DefaultIDTokenLifetime,
+				RefreshTokenIdle:     DefaultRefreshTokenIdleExpiration,
+				RefreshTokenAbsolute: DefaultRefreshTokenExpiration,
+			},
+		},
+		{
+			name: "no settings",
+			mock: mockQueryErr(stmt, sql.ErrNoRows, "instance-id", "instance-id"),
+			want: &TokenLifetimes{
+				AccessToken:          DefaultAccessTokenLifetime,
+				IDToken:              DefaultIDTokenLifetime,
+				RefreshTokenIdle:     DefaultRefreshTokenIdleExpiration,
+				RefreshTokenAbsolute: DefaultRefreshTokenExpiration,
+			},
+		},
+		{
+			name:    "sql error",
+			mock:    mockQueryErr(stmt, sql.ErrConnDone, "instance-id", "instance-id"),
+			wantErr: zerrors.IsInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceTokenLifetimes(authz.WithInstanceID(context.Background(), "instance-id"))
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
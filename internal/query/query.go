@@ -42,8 +42,18 @@ type Queries struct {
 	zitadelRoles                        []authz.RoleMapping
 	multifactors                        domain.MultifactorConfigs
 	defaultAuditLogRetention            time.Duration
+
+	instanceCacheRefreshAhead time.Duration
+	maxInstanceResultSize     uint64
+	instanceNameNormalizer    func(string) string
+
+	externalSecure bool
+	externalPort   uint16
 }
 
+// QueryOption configures optional behavior of [Queries].
+type QueryOption func(*Queries)
+
 func StartQueries(
 	ctx context.Context,
 	es *eventstore.Eventstore,
@@ -59,6 +69,7 @@ func StartQueries(
 	defaultAuditLogRetention time.Duration,
 	systemAPIUsers map[string]*authz.SystemAPIUser,
 	startProjections bool,
+	opts ...QueryOption,
 ) (repo *Queries, err error) {
 	repo = &Queries{
 		eventstore:                          es,
@@ -79,6 +90,9 @@ func StartQueries(
 		},
 		defaultAuditLogRetention: defaultAuditLogRetention,
 	}
+	for _, opt := range opts {
+		opt(repo)
+	}
 
 	repo.checkPermission = permissionCheck(repo)
 
@@ -107,6 +107,65 @@ func prepareQuotaQuery(ctx context.Context, db prepareDatabase) (sq.SelectBuilde
 		}
 }
 
+// InstanceQuotas are the quotas configured for an instance, by unit.
+// A nil quota means the unit is unlimited.
+type InstanceQuotas struct {
+	RequestsAllAuthenticated *Quota
+	ActionsAllRunsSeconds    *Quota
+}
+
+// instanceQuotas returns the quotas of the instance or nil if none are configured.
+func (q *Queries) instanceQuotas(ctx context.Context, instanceID string) (quotas *InstanceQuotas, err error) {
+	stmt, args, err := sq.
+		Select(
+			QuotaColumnUnit.identifier(),
+			QuotaColumnID.identifier(),
+			QuotaColumnFrom.identifier(),
+			QuotaColumnInterval.identifier(),
+			QuotaColumnAmount.identifier(),
+			QuotaColumnLimit.identifier(),
+			"now()",
+		).
+		From(quotasTable.identifier()).
+		Where(sq.Eq{QuotaColumnInstanceID.identifier(): instanceID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Yai9o", "Errors.Query.SQLStatement")
+	}
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				unit     quota.Unit
+				interval database.NullDuration
+				now      time.Time
+			)
+			qu := new(Quota)
+			if err := rows.Scan(&unit, &qu.ID, &qu.From, &interval, &qu.Amount, &qu.Limit, &now); err != nil {
+				return err
+			}
+			qu.ResetInterval = interval.Duration
+			qu.CurrentPeriodStart = pushPeriodStart(qu.From, qu.ResetInterval, now)
+			if quotas == nil {
+				quotas = new(InstanceQuotas)
+			}
+			switch unit {
+			case quota.RequestsAllAuthenticated:
+				quotas.RequestsAllAuthenticated = qu
+			case quota.ActionsAllRunsSeconds:
+				quotas.ActionsAllRunsSeconds = qu
+			case quota.Unimplemented:
+				// not enforced
+			}
+		}
+		return rows.Err()
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-Eiw3u", "Errors.Internal")
+	}
+	return quotas, nil
+}
+
 func pushPeriodStart(from time.Time, interval time.Duration, now time.Time) time.Time {
 	if now.IsZero() {
 		now = time.Now()
@@ -85,3 +85,42 @@ func prepareRemainingQuotaUsageQuery(ctx context.Context, db prepareDatabase) (s
 			return remaining, nil
 		}
 }
+
+// instanceOverQuotaQuery matches the instances whose usage in the current period of unit reached the amount of the quota.
+// Like [Queries.GetRemainingQuotaUsage] only limiting quotas are considered, quotas which only notify are never exceeded.
+type instanceOverQuotaQuery struct {
+	unit quota.Unit
+}
+
+func (q *instanceOverQuotaQuery) Col() Column {
+	return InstanceColumnID
+}
+
+func (q *instanceOverQuotaQuery) toQuery(query sq.SelectBuilder) sq.SelectBuilder {
+	return query.Where(q.comp())
+}
+
+func (q *instanceOverQuotaQuery) comp() sq.Sqlizer {
+	return q
+}
+
+func (q *instanceOverQuotaQuery) ToSql() (string, []interface{}, error) {
+	subSelect, args, err := sq.Select(QuotaPeriodColumnInstanceID.identifier()).
+		From(quotaPeriodsTable.identifier()).
+		Join(join(QuotaColumnUnit, QuotaPeriodColumnUnit)).
+		Where(sq.And{
+			sq.Eq{
+				QuotaPeriodColumnUnit.identifier(): q.unit,
+				QuotaColumnLimit.identifier():      true,
+			},
+			sq.Expr("age(" + QuotaPeriodColumnStart.identifier() + ") < " + QuotaColumnInterval.identifier()),
+			sq.Expr(QuotaPeriodColumnStart.identifier() + " <= now()"),
+			sq.Expr(QuotaPeriodColumnStart.identifier() + " >= " + QuotaColumnFrom.identifier()),
+			sq.Expr(QuotaPeriodColumnUsage.identifier() + " >= " + QuotaColumnAmount.identifier()),
+		}).
+		ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return InstanceColumnID.identifier() + " IN ( " + subSelect + " )", args, nil
+}
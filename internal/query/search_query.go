@@ -521,6 +521,45 @@ func (q *listQuery) Col() Column {
 	return q.Column
 }
 
+// validateSearchQuery checks that query, including the queries it combines, results in a valid statement.
+func validateSearchQuery(query SearchQuery) error {
+	switch q := query.(type) {
+	case nil:
+		return ErrNothingSelected
+	case *OrQuery:
+		return validateSearchQueries(q.queries)
+	case *AndQuery:
+		return validateSearchQueries(q.queries)
+	case *NotQuery:
+		return validateSearchQuery(q.query)
+	case *listQuery:
+		if _, ok := q.Data.(*SubSelect); ok {
+			break
+		}
+		if data := reflect.ValueOf(q.Data); data.Kind() == reflect.Slice && data.Len() == 0 {
+			return ErrEmptyValues
+		}
+	}
+	comp := query.comp()
+	if comp == nil {
+		return ErrInvalidCompare
+	}
+	_, _, err := comp.ToSql()
+	return err
+}
+
+func validateSearchQueries(queries []SearchQuery) error {
+	if len(queries) == 0 {
+		return ErrNothingSelected
+	}
+	for _, query := range queries {
+		if err := validateSearchQuery(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type ListComparison int
 
 const (
@@ -170,6 +170,22 @@ func (q *Queries) SMTPConfigActive(ctx context.Context, resourceOwner string) (c
 	return config, err
 }
 
+// InstanceSMTPConfig returns the active SMTP configuration of the instance in ctx.
+// A NotFound error is returned if no SMTP provider is active for the instance.
+func (q *Queries) InstanceSMTPConfig(ctx context.Context) (config *SMTPConfig, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	config, err = q.SMTPConfigActive(ctx, authz.GetInstance(ctx).InstanceID())
+	if err != nil {
+		return nil, err
+	}
+	if config.SMTPConfig == nil {
+		return nil, zerrors.ThrowNotFound(nil, "QUERY-Oow6e", "Errors.SMTPConfig.NotFound")
+	}
+	return config, nil
+}
+
 func (q *Queries) SMTPConfigByID(ctx context.Context, instanceID, id string) (config *SMTPConfig, err error) {
 	ctx, span := tracing.NewSpan(ctx)
 	defer func() { span.EndWithError(err) }()
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -8,7 +9,12 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/api/authz"
 	"github.com/zitadel/zitadel/internal/crypto"
+	"github.com/zitadel/zitadel/internal/database"
 	"github.com/zitadel/zitadel/internal/domain"
 	"github.com/zitadel/zitadel/internal/zerrors"
 )
@@ -291,3 +297,110 @@ func Test_SMTPConfigsPrepares(t *testing.T) {
 		})
 	}
 }
+
+func TestQueries_InstanceSMTPConfig(t *testing.T) {
+	stmt := prepareSMTPConfigStmt +
+		` WHERE projections.smtp_configs5.instance_id = $1` +
+		` AND projections.smtp_configs5.resource_owner = $2` +
+		` AND projections.smtp_configs5.state = $3`
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    *SMTPConfig
+		wantErr func(error) bool
+	}{
+		{
+			name: "configured",
+			mock: mockQuery(
+				regexp.QuoteMeta(stmt),
+				prepareSMTPConfigCols,
+				[]driver.Value{
+					testNow,
+					testNow,
+					"instance-id",
+					uint64(20211108),
+					"2232323",
+					domain.SMTPConfigStateActive,
+					"test",
+					"2232323",
+					true,
+					"sender",
+					"name",
+					"reply-to",
+					"host",
+					"user",
+					&crypto.CryptoValue{},
+					nil,
+					nil,
+				},
+				"instance-id", "instance-id", domain.SMTPConfigStateActive,
+			),
+			want: &SMTPConfig{
+				CreationDate:  testNow,
+				ChangeDate:    testNow,
+				ResourceOwner: "instance-id",
+				Sequence:      20211108,
+				SMTPConfig: &SMTP{
+					TLS:            true,
+					SenderAddress:  "sender",
+					SenderName:     "name",
+					ReplyToAddress: "reply-to",
+					Host:           "host",
+					User:           "user",
+					Password:       &crypto.CryptoValue{},
+				},
+				ID:          "2232323",
+				State:       domain.SMTPConfigStateActive,
+				Description: "test",
+			},
+		},
+		{
+			name: "http provider active",
+			mock: mockQuery(
+				regexp.QuoteMeta(stmt),
+				prepareSMTPConfigCols,
+				[]driver.Value{
+					testNow,
+					testNow,
+					"instance-id",
+					uint64(20211108),
+					"2232323",
+					domain.SMTPConfigStateActive,
+					"test",
+					nil,
+					nil,
+					nil,
+					nil,
+					nil,
+					nil,
+					nil,
+					nil,
+					"2232323",
+					"endpoint",
+				},
+				"instance-id", "instance-id", domain.SMTPConfigStateActive,
+			),
+			wantErr: zerrors.IsNotFound,
+		},
+		{
+			name:    "unconfigured",
+			mock:    mockQueryErr(regexp.QuoteMeta(stmt), sql.ErrNoRows, "instance-id", "instance-id", domain.SMTPConfigStateActive),
+			wantErr: zerrors.IsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				ctx := authz.WithInstanceID(context.Background(), "instance-id")
+				got, err := q.InstanceSMTPConfig(ctx)
+				if tt.wantErr != nil {
+					require.True(t, tt.wantErr(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}
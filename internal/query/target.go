@@ -11,6 +11,7 @@ import (
 	"github.com/zitadel/zitadel/internal/api/authz"
 	"github.com/zitadel/zitadel/internal/domain"
 	"github.com/zitadel/zitadel/internal/query/projection"
+	"github.com/zitadel/zitadel/internal/telemetry/tracing"
 	"github.com/zitadel/zitadel/internal/zerrors"
 )
 
@@ -59,6 +60,15 @@ var (
 		name:  projection.TargetInterruptOnErrorCol,
 		table: targetTable,
 	}
+
+	executionTargetsColumnTargetID = Column{
+		name:  projection.ExecutionTargetTargetIDCol,
+		table: executionTargetsTable,
+	}
+	executionTargetsColumnExecutionID = Column{
+		name:  projection.ExecutionTargetExecutionIDCol,
+		table: executionTargetsTable,
+	}
 )
 
 type Targets struct {
@@ -110,6 +120,66 @@ func (q *Queries) GetTargetByID(ctx context.Context, id string) (target *Target,
 	return genericRowQuery[*Target](ctx, q.client, query.Where(eq), scan)
 }
 
+// ActionTarget is a target of an instance together with the executions triggering it.
+type ActionTarget struct {
+	ID         string
+	Name       string
+	TargetType domain.TargetType
+	URL        string
+	// Triggers are the IDs of the executions calling the target directly, for example "request/zitadel.session.v2.SessionService/ListSessions".
+	// Executions including another execution which calls the target are not listed.
+	Triggers []string
+}
+
+// InstanceActionTargets returns all targets of the instance ordered by their ID.
+func (q *Queries) InstanceActionTargets(ctx context.Context, instanceID string) (targets []*ActionTarget, err error) {
+	ctx, span := tracing.NewSpan(ctx)
+	defer func() { span.EndWithError(err) }()
+
+	stmt, args, err := sq.Select(
+		TargetColumnID.identifier(),
+		TargetColumnName.identifier(),
+		TargetColumnTargetType.identifier(),
+		TargetColumnURL.identifier(),
+		executionTargetsColumnExecutionID.identifier(),
+	).
+		From(targetTable.identifier()).
+		LeftJoin(join(executionTargetsColumnTargetID, TargetColumnID)).
+		Where(sq.Eq{TargetColumnInstanceID.identifier(): instanceID}).
+		OrderBy(TargetColumnID.identifier(), executionTargetsColumnExecutionID.identifier()).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-k2vd8q0wbl", "Errors.Query.SQLStatement")
+	}
+
+	targets = make([]*ActionTarget, 0)
+	err = q.client.QueryContext(ctx, func(rows *sql.Rows) error {
+		for rows.Next() {
+			var (
+				target      ActionTarget
+				executionID sql.NullString
+			)
+			if err := rows.Scan(&target.ID, &target.Name, &target.TargetType, &target.URL, &executionID); err != nil {
+				return err
+			}
+			if len(targets) == 0 || targets[len(targets)-1].ID != target.ID {
+				target.Triggers = make([]string, 0, 1)
+				targets = append(targets, &target)
+			}
+			if executionID.Valid {
+				last := targets[len(targets)-1]
+				last.Triggers = append(last.Triggers, executionID.String)
+			}
+		}
+		return rows.Err()
+	}, stmt, args...)
+	if err != nil {
+		return nil, zerrors.ThrowInternal(err, "QUERY-pz4l1s6tma", "Errors.Internal")
+	}
+	return targets, nil
+}
+
 func NewTargetNameSearchQuery(method TextComparison, value string) (SearchQuery, error) {
 	return NewTextQuery(TargetColumnName, value, method)
 }
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -9,6 +10,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zitadel/zitadel/internal/database"
 	"github.com/zitadel/zitadel/internal/domain"
 	"github.com/zitadel/zitadel/internal/zerrors"
 )
@@ -311,3 +316,57 @@ func Test_TargetPrepares(t *testing.T) {
 		})
 	}
 }
+
+func TestQueries_InstanceActionTargets(t *testing.T) {
+	stmt := regexp.QuoteMeta(`SELECT projections.targets1.id, projections.targets1.name, projections.targets1.target_type, projections.targets1.endpoint, projections.executions1_targets.execution_id` +
+		` FROM projections.targets1` +
+		` LEFT JOIN projections.executions1_targets ON projections.targets1.id = projections.executions1_targets.target_id AND projections.targets1.instance_id = projections.executions1_targets.instance_id` +
+		` WHERE projections.targets1.instance_id = $1` +
+		` ORDER BY projections.targets1.id, projections.executions1_targets.execution_id`)
+	cols := []string{"id", "name", "target_type", "endpoint", "execution_id"}
+	tests := []struct {
+		name    string
+		mock    sqlExpectation
+		want    []*ActionTarget
+		wantErr bool
+	}{
+		{
+			name: "several targets",
+			mock: mockQueries(stmt, cols, [][]driver.Value{
+				{"target1", "webhook", domain.TargetTypeWebhook, "https://example.com/webhook", "event/user.human.added"},
+				{"target1", "webhook", domain.TargetTypeWebhook, "https://example.com/webhook", "request/zitadel.session.v2.SessionService/ListSessions"},
+				{"target2", "call", domain.TargetTypeCall, "https://example.com/call", "function/preuserinfo"},
+				{"target3", "unused", domain.TargetTypeAsync, "https://example.com/async", nil},
+			}, "instanceID"),
+			want: []*ActionTarget{
+				{ID: "target1", Name: "webhook", TargetType: domain.TargetTypeWebhook, URL: "https://example.com/webhook", Triggers: []string{"event/user.human.added", "request/zitadel.session.v2.SessionService/ListSessions"}},
+				{ID: "target2", Name: "call", TargetType: domain.TargetTypeCall, URL: "https://example.com/call", Triggers: []string{"function/preuserinfo"}},
+				{ID: "target3", Name: "unused", TargetType: domain.TargetTypeAsync, URL: "https://example.com/async", Triggers: []string{}},
+			},
+		},
+		{
+			name: "no targets",
+			mock: mockQueries(stmt, cols, nil, "instanceID"),
+			want: []*ActionTarget{},
+		},
+		{
+			name:    "sql error",
+			mock:    mockQueryErr(stmt, sql.ErrConnDone, "instanceID"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execMock(t, tt.mock, func(db *sql.DB) {
+				q := &Queries{client: &database.DB{DB: db, Database: new(prepareDB)}}
+				got, err := q.InstanceActionTargets(context.Background(), "instanceID")
+				if tt.wantErr {
+					require.True(t, zerrors.IsInternal(err), "unexpected error: %v", err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			})
+		})
+	}
+}